@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheInfo describes a cached TimeMap for the admin endpoints
+type CacheInfo struct {
+	URIR     string         `json:"original_uri"`
+	Source   string         `json:"source"`
+	Fetched  time.Time      `json:"fetched"`
+	Expires  time.Time      `json:"expires"`
+	Mementos int            `json:"mementos"`
+	Archives map[string]int `json:"archives"`
+}
+
+func newCacheInfo(ce *CacheEntry, source string) CacheInfo {
+	return CacheInfo{
+		URIR:     ce.URIR,
+		Source:   source,
+		Fetched:  ce.Fetched,
+		Expires:  ce.Fetched.Add(*cachettl),
+		Mementos: ce.Timemap.Len(),
+		Archives: ce.Archives,
+	}
+}
+
+func cacheInfos(urir string) (infos []CacheInfo) {
+	infos = []CacheInfo{}
+	seen := make(map[string]bool)
+	if tmCache != nil {
+		for _, ce := range tmCache.Entries() {
+			key := cacheKey(ce.URIR)
+			if urir == "" || key == cacheKey(urir) {
+				seen[key] = true
+				infos = append(infos, newCacheInfo(ce, "memory"))
+			}
+		}
+	}
+	if tmDisk != nil {
+		for _, ce := range tmDisk.Entries() {
+			key := cacheKey(ce.URIR)
+			if !seen[key] && (urir == "" || key == cacheKey(urir)) {
+				infos = append(infos, newCacheInfo(ce, "disk"))
+			}
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Fetched.After(infos[j].Fetched)
+	})
+	return
+}
+
+// purgeCache removes the entries of the URI-R, or all entries, from both caches, counting each URI-R once
+func purgeCache(urir string) (n int) {
+	if urir == "" {
+		keys := make(map[string]bool)
+		if tmCache != nil {
+			for _, ce := range tmCache.Entries() {
+				keys[cacheKey(ce.URIR)] = true
+			}
+			tmCache.Purge()
+		}
+		if tmDisk != nil {
+			for _, ce := range tmDisk.Entries() {
+				keys[cacheKey(ce.URIR)] = true
+			}
+			tmDisk.Purge()
+		}
+		return len(keys)
+	}
+	deleted := false
+	if tmCache != nil && tmCache.Delete(urir) {
+		deleted = true
+	}
+	if tmDisk != nil && tmDisk.Delete(urir) {
+		deleted = true
+	}
+	if deleted {
+		n = 1
+	}
+	return
+}
+
+// bearerToken returns the token of an Authorization header with the Bearer scheme, matched case-insensitively
+func bearerToken(r *http.Request) (token string, ok bool) {
+	p := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(p) != 2 || !strings.EqualFold(p[0], "Bearer") {
+		return
+	}
+	return strings.TrimSpace(p[1]), true
+}
+
+func authorizedAdmin(r *http.Request) bool {
+	token, ok := bearerToken(r)
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(*admintoken)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logError.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+func adminService(w http.ResponseWriter, r *http.Request, requri string) {
+	if *admintoken == "" {
+		logError.Printf("Admin endpoints not enabled, use --admintoken flag to enable them")
+		http.Error(w, "Admin endpoints not enabled", http.StatusNotImplemented)
+		return
+	}
+	if !authorizedAdmin(r) {
+		logError.Printf("Unauthorized admin request: %s", r.URL.RequestURI())
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+Name+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	p := strings.SplitN(requri, "/", 3)
+	action := ""
+	if len(p) > 1 {
+		action = strings.SplitN(p[1], "?", 2)[0]
+	}
+	switch action {
+	case "cache":
+		if tmCache == nil && tmDisk == nil {
+			http.Error(w, "TimeMap caching not enabled", http.StatusNotImplemented)
+			return
+		}
+		urir := ""
+		if len(p) > 2 && p[2] != "" {
+			var err error
+			urir, err = parseURI(p[2])
+			if err != nil {
+				http.Error(w, "Malformed URI-R: "+p[2], http.StatusBadRequest)
+				return
+			}
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			infos := cacheInfos(urir)
+			if urir != "" && len(infos) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			logInfo.Printf("Cache entries listed: %d", len(infos))
+			writeJSON(w, infos)
+		case http.MethodDelete:
+			n := purgeCache(urir)
+			logInfo.Printf("Cache entries purged: %d", n)
+			writeJSON(w, map[string]int{"purged": n})
+		default:
+			w.Header().Set("Allow", "GET, HEAD, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "reload":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		arcs, err := reloadArchives()
+		if err != nil {
+			http.Error(w, "Error reloading list of archives: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]int{"archives": len(arcs)})
+	default:
+		http.Error(w, "Unknown admin endpoint: "+r.URL.RequestURI()+"\nExpected: /admin/cache[/{URI-R}] or /admin/reload", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizedAdmin(t *testing.T) {
+	defer func(tok string) { *admintoken = tok }(*admintoken)
+	*admintoken = "s3cret"
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"Bearer s3cret", true},
+		{"bearer s3cret", true},
+		{"BEARER s3cret", true},
+		{"s3cret", false},
+		{"Basic s3cret", false},
+		{"Bearer wrong", false},
+		{"Bearer", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/admin/cache", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got := authorizedAdmin(r); got != tt.want {
+			t.Errorf("authorizedAdmin(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is read from and written to JSON as a string such as "90s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return
+	}
+	switch val := v.(type) {
+	case float64:
+		d.Duration = time.Duration(val * float64(time.Second))
+	case string:
+		d.Duration, err = time.ParseDuration(val)
+	default:
+		err = fmt.Errorf("invalid duration: %s", b)
+	}
+	return
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// ArchiveAuth holds the credentials of an access-restricted archive
+type ArchiveAuth struct {
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	PasswordEnv string `json:"passwordenv,omitempty"`
+	Token       string `json:"token,omitempty"`
+	TokenEnv    string `json:"tokenenv,omitempty"`
+	TokenFile   string `json:"tokenfile,omitempty"`
+	Cert        string `json:"cert,omitempty"`
+	Key         string `json:"key,omitempty"`
+}
+
+func (auth *ArchiveAuth) resolve() (password string, token string, err error) {
+	password = auth.Password
+	if auth.PasswordEnv != "" {
+		password = os.Getenv(auth.PasswordEnv)
+	}
+	token = auth.Token
+	if auth.TokenEnv != "" {
+		token = os.Getenv(auth.TokenEnv)
+	}
+	if auth.TokenFile != "" {
+		var body []byte
+		body, err = os.ReadFile(auth.TokenFile)
+		if err != nil {
+			return
+		}
+		token = strings.TrimSpace(string(body))
+	}
+	return
+}
+
+// archiveTransport adds the headers and credentials of an archive to requests made to the hosts of that archive only
+type archiveTransport struct {
+	hosts    map[string]bool
+	headers  map[string]string
+	username string
+	password string
+	token    string
+	scoped   http.RoundTripper
+	base     http.RoundTripper
+}
+
+func (t *archiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hosts[req.URL.Host] {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	if t.username != "" {
+		req.SetBasicAuth(t.username, t.password)
+	} else if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.scoped.RoundTrip(req)
+}
+
+// proxyTransport forwards each proxied request through the transport of the archive it belongs to
+type proxyTransport struct{}
+
+func (proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, arch := range currentArchives() {
+		if at, ok := arch.transport.(*archiveTransport); ok && at.hosts[req.URL.Host] {
+			return at.RoundTrip(req)
+		}
+	}
+	return transport.RoundTrip(req)
+}
+
+func (a Archives) initNetwork() (err error) {
+	for _, arch := range a {
+		err = arch.initNetwork()
+		if err != nil {
+			return fmt.Errorf("%s => %v", arch.ID, err)
+		}
+	}
+	return
+}
+
+func (arch *Archive) initNetwork() (err error) {
+	ct, ht, rt := *contimeout, *hdrtimeout, *restimeout
+	if arch.ConTimeout.Duration > 0 {
+		ct = arch.ConTimeout.Duration
+	}
+	if arch.HdrTimeout.Duration > 0 {
+		ht = arch.HdrTimeout.Duration
+	}
+	if arch.ResTimeout.Duration > 0 {
+		rt = arch.ResTimeout.Duration
+	}
+	var base http.RoundTripper = transport
+	if ct != *contimeout || ht != *hdrtimeout || rt != *restimeout {
+		base = newTransport(ct, ht, rt)
+	}
+	arch.transport = base
+	if len(arch.Headers) > 0 || arch.Auth != nil {
+		at := &archiveTransport{
+			hosts:   make(map[string]bool),
+			headers: arch.Headers,
+			scoped:  base,
+			base:    base,
+		}
+		for _, loc := range []string{arch.Timemap, arch.Timegate} {
+			u, err := url.Parse(loc)
+			if err != nil {
+				return err
+			}
+			at.hosts[u.Host] = true
+		}
+		if arch.Auth != nil {
+			at.username = arch.Auth.Username
+			at.password, at.token, err = arch.Auth.resolve()
+			if err != nil {
+				return
+			}
+			if arch.Auth.Cert != "" {
+				var cert tls.Certificate
+				cert, err = tls.LoadX509KeyPair(arch.Auth.Cert, arch.Auth.Key)
+				if err != nil {
+					return
+				}
+				tr := newTransport(ct, ht, rt)
+				tr.TLSClientConfig = &tls.Config{
+					Certificates: []tls.Certificate{cert},
+				}
+				at.scoped = tr
+			}
+		}
+		arch.transport = at
+	}
+	arch.client = client
+	if arch.transport != transport {
+		arch.client = &http.Client{
+			Transport: arch.transport,
+			Timeout:   rt,
+		}
+	}
+	if arch.MaxInFlight > 0 {
+		arch.slots = make(chan struct{}, arch.MaxInFlight)
+	}
+	return
+}
+
+func (arch *Archive) acquire(ctx context.Context) bool {
+	if arch.slots == nil {
+		return true
+	}
+	timer := time.NewTimer(arch.client.Timeout)
+	defer timer.Stop()
+	select {
+	case arch.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (arch *Archive) release() {
+	if arch.slots != nil {
+		<-arch.slots
+	}
+}
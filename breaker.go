@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of an archive
+type BreakerState int
+
+// Circuit breaker states
+const (
+	Closed BreakerState = iota
+	Open
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Breaker stops requests to an archive after consecutive failures, then lets a probe request through once the dormant period is over
+type Breaker struct {
+	sync.Mutex
+	id       string
+	state    BreakerState
+	failures int
+	trips    int
+	until    time.Time
+	probing  bool
+}
+
+func newBreaker(id string) *Breaker {
+	return &Breaker{id: id}
+}
+
+// Allow tells whether a request can be sent, switching an open breaker to half-open for a probe request once it is due
+func (b *Breaker) Allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case Open:
+		if time.Now().Before(b.until) {
+			return false
+		}
+		b.state = HalfOpen
+		b.probing = true
+		logInfo.Printf("%s => Probing after %s dormant", b.id, b.backoff())
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Cancel gives up the probe slot of a request that was allowed but never sent
+func (b *Breaker) Cancel() {
+	b.Lock()
+	defer b.Unlock()
+	b.probing = false
+}
+
+// Success closes the breaker and resets the failure and trip counts
+func (b *Breaker) Success() {
+	b.Lock()
+	defer b.Unlock()
+	if b.state == HalfOpen {
+		logInfo.Printf("%s => Awake after a successful probe", b.id)
+	}
+	b.state = Closed
+	b.failures = 0
+	b.trips = 0
+	b.probing = false
+}
+
+// Failure counts a failed request and opens the breaker when the failure tolerance is reached, a probe fails, or the archive asks to retry after a while
+func (b *Breaker) Failure(retryAfter time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+	b.failures++
+	b.probing = false
+	if *tolerance == -1 {
+		return
+	}
+	if b.state == HalfOpen || (b.state == Closed && (b.failures >= *tolerance || retryAfter > 0)) {
+		b.trips++
+		b.state = Open
+		d := b.backoff()
+		if retryAfter > 0 {
+			d = retryAfter
+			if d > *maxdormant {
+				d = *maxdormant
+			}
+		}
+		b.until = time.Now().Add(d)
+		logInfo.Printf("%s => Dormant for %s after %d consecutive failures", b.id, d, b.failures)
+	}
+}
+
+// backoff doubles the dormant period for each consecutive trip, up to the maximum dormant period
+func (b *Breaker) backoff() (d time.Duration) {
+	d = *dormant
+	for i := 1; i < b.trips && d < *maxdormant; i++ {
+		d *= 2
+	}
+	if d > *maxdormant {
+		d = *maxdormant
+	}
+	return
+}
+
+// State returns the current state of the breaker, an open breaker whose dormant period is over being due for a probe and so half-open
+func (b *Breaker) State() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+	return b.current()
+}
+
+func (b *Breaker) current() BreakerState {
+	if b.state == Open && !time.Now().Before(b.until) {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Status returns a summary of the breaker for the service info, empty if it is closed without failures
+func (b *Breaker) Status() string {
+	b.Lock()
+	defer b.Unlock()
+	switch b.current() {
+	case Open:
+		return fmt.Sprintf("DORMANT until %s, trips: %d", b.until.Format(time.RFC3339), b.trips)
+	case HalfOpen:
+		if b.state == Open {
+			return fmt.Sprintf("PROBE DUE since %s, trips: %d", b.until.Format(time.RFC3339), b.trips)
+		}
+		return fmt.Sprintf("PROBING, trips: %d", b.trips)
+	}
+	if b.failures > 0 {
+		return fmt.Sprintf("Consecutive failures: %d", b.failures)
+	}
+	return ""
+}
+
+var failureClasses = map[string]bool{
+	"network": false,
+	"5xx":     false,
+	"429":     false,
+	"parse":   false,
+	"slow":    false,
+}
+
+func initFailureClasses() {
+	for _, class := range strings.Split(*failon, ",") {
+		class = strings.ToLower(strings.TrimSpace(class))
+		if class == "" {
+			continue
+		}
+		if _, ok := failureClasses[class]; !ok {
+			logFatal.Fatalf("Unknown failure class (%s): %s\n", *failon, class)
+		}
+		failureClasses[class] = true
+	}
+}
+
+func failsOn(class string) bool {
+	return failureClasses[class]
+}
+
+func statusFailure(status int) string {
+	if status == http.StatusTooManyRequests {
+		return "429"
+	}
+	if status >= 500 {
+		return "5xx"
+	}
+	return ""
+}
+
+// responseFailure classifies a successful response with a body that is not link-format, or that took too long
+func responseFailure(lnks string, count int, latency time.Duration) string {
+	if count == 0 && strings.TrimSpace(lnks) != "" && !regs["lnkfrmt"].MatchString(lnks) {
+		return "parse"
+	}
+	if latency > *slowlimit {
+		return "slow"
+	}
+	return ""
+}
+
+// retryAfter returns the delay asked by a 429 or 503 response in its Retry-After header
+func retryAfter(res *http.Response) (d time.Duration) {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	ra := strings.TrimSpace(res.Header.Get("Retry-After"))
+	if ra == "" {
+		return
+	}
+	if secs, err := strconv.Atoi(ra); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(ra); err == nil {
+		d = time.Until(t)
+	}
+	return
+}
+
+func (a Archives) initBreakers() {
+	for _, arch := range a {
+		arch.breaker = newBreaker(arch.ID)
+	}
+}
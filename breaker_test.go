@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func setBreakerFlags(t *testing.T, tol int, d time.Duration, maxd time.Duration) {
+	prevTol, prevD, prevMaxD, prevLog := *tolerance, *dormant, *maxdormant, logInfo
+	t.Cleanup(func() {
+		*tolerance, *dormant, *maxdormant, logInfo = prevTol, prevD, prevMaxD, prevLog
+	})
+	*tolerance, *dormant, *maxdormant = tol, d, maxd
+	logInfo = log.New(io.Discard, "", 0)
+}
+
+func TestBreakerBackoff(t *testing.T) {
+	setBreakerFlags(t, 3, time.Minute, 5*time.Minute)
+	tests := []struct {
+		trips int
+		want  time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 5 * time.Minute},
+		{10, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		b := &Breaker{trips: tt.trips}
+		if got := b.backoff(); got != tt.want {
+			t.Errorf("backoff() after %d trips = %s, want %s", tt.trips, got, tt.want)
+		}
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	setBreakerFlags(t, 2, time.Minute, 3*time.Minute)
+	b := newBreaker("test")
+	elapse := func() {
+		b.until = time.Now().Add(-time.Second)
+	}
+	tests := []struct {
+		name      string
+		step      func()
+		state     BreakerState
+		allow     bool
+		trips     int
+		remaining time.Duration
+	}{
+		{"first failure", func() { b.Failure(0) }, Closed, true, 0, 0},
+		{"tolerance reached", func() { b.Failure(0) }, Open, false, 1, time.Minute},
+		{"dormant period over", elapse, HalfOpen, true, 1, 0},
+		{"probe in flight", func() {}, HalfOpen, false, 1, 0},
+		{"probe failed", func() { b.Failure(0) }, Open, false, 2, 2 * time.Minute},
+		{"second probe due", elapse, HalfOpen, true, 2, 0},
+		{"second probe failed", func() { b.Failure(0) }, Open, false, 3, 3 * time.Minute},
+		{"third probe due", elapse, HalfOpen, true, 3, 0},
+		{"probe succeeded", func() { b.Success() }, Closed, true, 0, 0},
+		{"retry after", func() { b.Failure(30 * time.Second) }, Open, false, 1, 30 * time.Second},
+		{"retry after capped", func() { elapse(); b.Allow(); b.Failure(time.Hour) }, Open, false, 2, 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		tt.step()
+		if got := b.State(); got != tt.state {
+			t.Fatalf("%s: State() = %s, want %s", tt.name, got, tt.state)
+		}
+		if b.trips != tt.trips {
+			t.Fatalf("%s: trips = %d, want %d", tt.name, b.trips, tt.trips)
+		}
+		if tt.remaining > 0 {
+			if got := time.Until(b.until); got > tt.remaining || got < tt.remaining-time.Second {
+				t.Fatalf("%s: dormant for %s, want %s", tt.name, got, tt.remaining)
+			}
+		}
+		if got := b.Allow(); got != tt.allow {
+			t.Fatalf("%s: Allow() = %v, want %v", tt.name, got, tt.allow)
+		}
+	}
+}
+
+func TestBreakerCancelFreesProbe(t *testing.T) {
+	setBreakerFlags(t, 1, time.Minute, time.Hour)
+	b := newBreaker("test")
+	b.Failure(0)
+	b.until = time.Now().Add(-time.Second)
+	if !b.Allow() {
+		t.Fatal("Allow() = false for a due probe")
+	}
+	if b.Allow() {
+		t.Fatal("Allow() = true while a probe is in flight")
+	}
+	b.Cancel()
+	if !b.Allow() {
+		t.Fatal("Allow() = false after the probe was cancelled")
+	}
+}
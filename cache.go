@@ -0,0 +1,465 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheEntry holds an aggregated TimeMap along with the time it was fetched and the archives it came from
+type CacheEntry struct {
+	URIR     string
+	Fetched  time.Time
+	Archives map[string]int
+	Timemap  *list.List
+}
+
+// Expired tells whether the entry has outlived the given TTL
+func (ce *CacheEntry) Expired(ttl time.Duration) bool {
+	return time.Since(ce.Fetched) > ttl
+}
+
+// TimemapCache is a size bounded LRU cache of TimeMaps with per-entry TTL
+type TimemapCache struct {
+	sync.Mutex
+	size      int
+	ttl       time.Duration
+	stale     time.Duration
+	lru       *list.List
+	items     map[string]*list.Element
+	Hits      int64
+	StaleHits int64
+	Misses    int64
+}
+
+// NewTimemapCache returns an empty cache holding at most size entries, which are kept for stale period after their TTL
+func NewTimemapCache(size int, ttl time.Duration, stale time.Duration) *TimemapCache {
+	return &TimemapCache{
+		size:  size,
+		ttl:   ttl,
+		stale: stale,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns a fresh or a tolerably stale entry for the URI-R, if cached
+func (c *TimemapCache) Get(urir string) (ce *CacheEntry, ok bool) {
+	key := cacheKey(urir)
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		ce = el.Value.(*CacheEntry)
+		if ce.Expired(c.ttl + c.stale) {
+			c.lru.Remove(el)
+			delete(c.items, key)
+			ce, ok = nil, false
+		}
+	}
+	if !ok {
+		c.Misses++
+		return
+	}
+	c.lru.MoveToFront(el)
+	if ce.Expired(c.ttl) {
+		c.StaleHits++
+	} else {
+		c.Hits++
+	}
+	return
+}
+
+// Put adds or replaces the entry of its URI-R and evicts the least recently used entries beyond the size limit
+func (c *TimemapCache) Put(ce *CacheEntry) {
+	key := cacheKey(ce.URIR)
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = ce
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(ce)
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, cacheKey(el.Value.(*CacheEntry).URIR))
+	}
+}
+
+// Entries returns all the entries currently held in the cache, most recently used first
+func (c *TimemapCache) Entries() (ces []*CacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		ces = append(ces, el.Value.(*CacheEntry))
+	}
+	return
+}
+
+// Delete removes the entry of the URI-R, if cached
+func (c *TimemapCache) Delete(urir string) (ok bool) {
+	key := cacheKey(urir)
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		c.lru.Remove(el)
+		delete(c.items, key)
+	}
+	return
+}
+
+// Purge removes all the entries from the cache
+func (c *TimemapCache) Purge() (n int) {
+	c.Lock()
+	defer c.Unlock()
+	n = c.lru.Len()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	return
+}
+
+// Len returns the number of entries currently held in the cache
+func (c *TimemapCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+// Stats returns a summary of the cache usage
+func (c *TimemapCache) Stats() string {
+	c.Lock()
+	defer c.Unlock()
+	return fmt.Sprintf("%d/%d entries, %d hits, %d stale hits, %d misses", c.lru.Len(), c.size, c.Hits, c.StaleHits, c.Misses)
+}
+
+// Counters returns the hits, stale hits, and misses of the cache
+func (c *TimemapCache) Counters() (hits int64, stale int64, misses int64) {
+	c.Lock()
+	defer c.Unlock()
+	return c.Hits, c.StaleHits, c.Misses
+}
+
+// DiskCache persists TimeMaps as one JSON file per URI-R in a directory
+type DiskCache struct {
+	sync.Mutex
+	dir       string
+	ttl       time.Duration
+	stale     time.Duration
+	Hits      int64
+	StaleHits int64
+	Misses    int64
+}
+
+type diskMemento struct {
+	URI      string `json:"uri"`
+	Datetime string `json:"datetime"`
+	Archive  string `json:"archive,omitempty"`
+}
+
+type diskEntry struct {
+	URIR     string         `json:"original_uri"`
+	Fetched  time.Time      `json:"fetched"`
+	Archives map[string]int `json:"archives"`
+	Mementos []diskMemento  `json:"mementos"`
+}
+
+// NewDiskCache returns a cache persisted in dir, creating the directory if needed
+func NewDiskCache(dir string, ttl time.Duration, stale time.Duration) (dc *DiskCache, err error) {
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return
+	}
+	dc = &DiskCache{
+		dir:   dir,
+		ttl:   ttl,
+		stale: stale,
+	}
+	return
+}
+
+func (dc *DiskCache) path(urir string) string {
+	sum := sha1.Sum([]byte(cacheKey(urir)))
+	return filepath.Join(dc.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (dc *DiskCache) count(ce *CacheEntry) {
+	dc.Lock()
+	defer dc.Unlock()
+	if ce == nil {
+		dc.Misses++
+	} else if ce.Expired(dc.ttl) {
+		dc.StaleHits++
+	} else {
+		dc.Hits++
+	}
+}
+
+// Get returns a fresh or a tolerably stale entry for the URI-R, if persisted
+func (dc *DiskCache) Get(urir string) (ce *CacheEntry, ok bool) {
+	defer func() {
+		if !ok {
+			ce = nil
+		}
+		dc.count(ce)
+	}()
+	return dc.read(dc.path(urir))
+}
+
+func (dc *DiskCache) read(fp string) (ce *CacheEntry, ok bool) {
+	body, err := os.ReadFile(fp)
+	if err != nil {
+		return
+	}
+	var de diskEntry
+	err = json.Unmarshal(body, &de)
+	if err != nil {
+		logError.Printf("Error parsing cached TimeMap (%s): %v", fp, err)
+		return
+	}
+	ce = &CacheEntry{
+		URIR:     de.URIR,
+		Fetched:  de.Fetched,
+		Archives: de.Archives,
+		Timemap:  list.New(),
+	}
+	if ce.Expired(dc.ttl + dc.stale) {
+		os.Remove(fp)
+		return
+	}
+	for _, m := range de.Mementos {
+		pdtm, err := time.Parse(http.TimeFormat, m.Datetime)
+		if err != nil {
+			logError.Printf("Error parsing datetime (%s): %v", m.Datetime, err)
+			continue
+		}
+		ce.Timemap.PushBack(Link{
+			Href:     m.URI,
+			Datetime: m.Datetime,
+			Timeobj:  pdtm,
+			Timestr:  pdtm.Format("20060102150405"),
+			Archive:  m.Archive,
+		})
+	}
+	ok = true
+	return
+}
+
+// Put writes the entry to the disk, replacing any previous copy of the same URI-R
+func (dc *DiskCache) Put(ce *CacheEntry) (err error) {
+	de := diskEntry{
+		URIR:     ce.URIR,
+		Fetched:  ce.Fetched,
+		Archives: ce.Archives,
+		Mementos: make([]diskMemento, 0, ce.Timemap.Len()),
+	}
+	for e := ce.Timemap.Front(); e != nil; e = e.Next() {
+		lnk := e.Value.(Link)
+		de.Mementos = append(de.Mementos, diskMemento{
+			URI:      lnk.Href,
+			Datetime: lnk.Datetime,
+			Archive:  lnk.Archive,
+		})
+	}
+	body, err := json.Marshal(de)
+	if err != nil {
+		return
+	}
+	fp := dc.path(ce.URIR)
+	tmp, err := os.CreateTemp(dc.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	err = os.Rename(tmp.Name(), fp)
+	return
+}
+
+// Entries returns all the unexpired entries persisted in the cache directory
+func (dc *DiskCache) Entries() (ces []*CacheEntry) {
+	fps, err := filepath.Glob(filepath.Join(dc.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, fp := range fps {
+		if ce, ok := dc.read(fp); ok {
+			ces = append(ces, ce)
+		}
+	}
+	return
+}
+
+// Delete removes the persisted entry of the URI-R, if any
+func (dc *DiskCache) Delete(urir string) bool {
+	return os.Remove(dc.path(urir)) == nil
+}
+
+// Purge removes all the entries persisted in the cache directory
+func (dc *DiskCache) Purge() (n int) {
+	fps, err := filepath.Glob(filepath.Join(dc.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, fp := range fps {
+		if os.Remove(fp) == nil {
+			n++
+		}
+	}
+	return
+}
+
+// Stats returns a summary of the persistent cache usage
+func (dc *DiskCache) Stats() string {
+	dc.Lock()
+	defer dc.Unlock()
+	return fmt.Sprintf("%s, %d hits, %d stale hits, %d misses", dc.dir, dc.Hits, dc.StaleHits, dc.Misses)
+}
+
+// Counters returns the hits, stale hits, and misses of the persistent cache
+func (dc *DiskCache) Counters() (hits int64, stale int64, misses int64) {
+	dc.Lock()
+	defer dc.Unlock()
+	return dc.Hits, dc.StaleHits, dc.Misses
+}
+
+var tmCache *TimemapCache
+var tmDisk *DiskCache
+
+var refreshing = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: make(map[string]bool)}
+
+func cacheKey(urir string) string {
+	u, err := url.Parse(urir)
+	if err != nil {
+		return urir
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+func copyTimemap(tm *list.List) (cp *list.List) {
+	cp = list.New()
+	for e := tm.Front(); e != nil; e = e.Next() {
+		lnk := e.Value.(Link)
+		lnk.NavRels = nil
+		cp.PushBack(lnk)
+	}
+	return
+}
+
+func provenance(tm *list.List) (arcs map[string]int) {
+	arcs = make(map[string]int)
+	for e := tm.Front(); e != nil; e = e.Next() {
+		arcs[e.Value.(Link).Archive]++
+	}
+	return
+}
+
+func cacheGet(urir string) (ce *CacheEntry, ok bool) {
+	if tmCache != nil {
+		ce, ok = tmCache.Get(urir)
+		if ok {
+			return
+		}
+	}
+	if tmDisk != nil {
+		ce, ok = tmDisk.Get(urir)
+		if ok && tmCache != nil {
+			tmCache.Put(ce)
+		}
+	}
+	return
+}
+
+func cachePut(ce *CacheEntry) {
+	if tmCache != nil {
+		tmCache.Put(ce)
+	}
+	if tmDisk != nil {
+		if err := tmDisk.Put(ce); err != nil {
+			logError.Printf("Error persisting TimeMap (%s): %v", ce.URIR, err)
+		}
+	}
+}
+
+func storeTimemap(urir string, basetm *list.List) {
+	cachePut(&CacheEntry{
+		URIR:     urir,
+		Fetched:  time.Now(),
+		Archives: provenance(basetm),
+		Timemap:  copyTimemap(basetm),
+	})
+}
+
+// revalidateTimemap refreshes a stale TimeMap in the background through a TimeMap flight, which caches it if complete
+func revalidateTimemap(urir string) {
+	key := cacheKey(urir)
+	refreshing.Lock()
+	if refreshing.keys[key] {
+		refreshing.Unlock()
+		return
+	}
+	refreshing.keys[key] = true
+	refreshing.Unlock()
+	defer func() {
+		refreshing.Lock()
+		delete(refreshing.keys, key)
+		refreshing.Unlock()
+	}()
+	start := time.Now()
+	sess := newSession(start)
+	defer benchmarker("SESSION", "revalidate", "Complete session", start, sess)
+	logInfo.Printf("Revalidating stale TimeMap of %s", urir)
+	coalesceTimemap(context.Background(), urir, nil, sess)
+}
+
+func lookupTimemap(ctx context.Context, urir string, dttmp *time.Time, sess *Session) (basetm *list.List) {
+	if tmCache == nil && tmDisk == nil {
+		return coalesceTimemap(ctx, urir, dttmp, sess)
+	}
+	start := time.Now()
+	if ce, ok := cacheGet(urir); ok {
+		sess.Cached = ce.Fetched
+		if ce.Expired(*cachettl) {
+			sess.Stale = true
+			go revalidateTimemap(urir)
+			benchmarker("AGGREGATOR", "cachelookup", fmt.Sprintf("%d stale Mementos found in cache", ce.Timemap.Len()), start, sess)
+			logInfo.Printf("Stale cache hit for %s", urir)
+		} else {
+			benchmarker("AGGREGATOR", "cachelookup", fmt.Sprintf("%d Mementos found in cache", ce.Timemap.Len()), start, sess)
+			logInfo.Printf("Cache hit for %s", urir)
+		}
+		return copyTimemap(ce.Timemap)
+	}
+	benchmarker("AGGREGATOR", "cachelookup", "Cache miss", start, sess)
+	return coalesceTimemap(ctx, urir, dttmp, sess)
+}
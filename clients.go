@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey holds the rate limit of a client key, each zero value falling back to its client rate flag
+type APIKey struct {
+	Rate  float64
+	Burst int
+}
+
+var apiKeys map[string]APIKey
+
+var clientLimiters = struct {
+	sync.Mutex
+	buckets map[string]*TokenBucket
+}{buckets: make(map[string]*TokenBucket)}
+
+// loadAPIKeys reads a file with one key per line, optionally followed by its own rate and burst, ignoring blank lines and # comments
+func loadAPIKeys(fp string) (keys map[string]APIKey, err error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	keys = make(map[string]APIKey)
+	scanner := bufio.NewScanner(f)
+	for ln := 1; scanner.Scan(); ln++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		var key APIKey
+		if len(fields) > 1 {
+			key.Rate, err = strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d => %v", fp, ln, err)
+			}
+		}
+		if len(fields) > 2 {
+			key.Burst, err = strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d => %v", fp, ln, err)
+			}
+		}
+		keys[fields[0]] = key
+	}
+	err = scanner.Err()
+	return
+}
+
+// take uses a token if one is available, otherwise it returns how long until one is
+func (tb *TokenBucket) take() (retry time.Duration, ok bool) {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.refill(time.Now())
+	if tb.tokens < 1 {
+		return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second)), false
+	}
+	tb.tokens--
+	return 0, true
+}
+
+// quota returns the remaining tokens and the time until the bucket is full again
+func (tb *TokenBucket) quota() (remaining int, reset time.Duration) {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.refill(time.Now())
+	return int(tb.tokens), time.Duration((tb.burst - tb.tokens) / tb.rate * float64(time.Second))
+}
+
+func (tb *TokenBucket) idle() bool {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.refill(time.Now())
+	return tb.tokens >= tb.burst
+}
+
+func clientKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	key, _ := bearerToken(r)
+	return key
+}
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func clientLimiter(id string, rate float64, burst int) *TokenBucket {
+	clientLimiters.Lock()
+	defer clientLimiters.Unlock()
+	tb, ok := clientLimiters.buckets[id]
+	if !ok {
+		tb = newTokenBucket(rate, burst)
+		clientLimiters.buckets[id] = tb
+	}
+	return tb
+}
+
+// admitClient authenticates the API key of an aggregation request and applies the rate limit of its key or IP, responding with 401 or 429 when it is refused
+func admitClient(w http.ResponseWriter, r *http.Request) bool {
+	rate, burst := *clientrate, *clientburst
+	id := "ip:" + clientIP(r)
+	if apiKeys != nil {
+		key := clientKey(r)
+		ak, ok := apiKeys[key]
+		if !ok {
+			logInfo.Printf("Rejected client %s: missing or unknown API key", clientIP(r))
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+Name+`"`)
+			http.Error(w, "Missing or unknown API key", http.StatusUnauthorized)
+			return false
+		}
+		id = "key:" + key
+		if ak.Rate > 0 {
+			rate = ak.Rate
+		}
+		if ak.Burst > 0 {
+			burst = ak.Burst
+		}
+	}
+	if rate <= 0 {
+		return true
+	}
+	tb := clientLimiter(id, rate, burst)
+	retry, ok := tb.take()
+	remaining, reset := tb.quota()
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", int(tb.burst)))
+	w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", int(math.Ceil(reset.Seconds()))))
+	if !ok {
+		logInfo.Printf("Rate limited client %s", clientIP(r))
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retry.Seconds()))))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// sweepClients drops the rate limiters of clients that have been idle long enough to refill their buckets
+func sweepClients() {
+	for range time.Tick(time.Minute) {
+		clientLimiters.Lock()
+		for id, tb := range clientLimiters.buckets {
+			if tb.idle() {
+				delete(clientLimiters.buckets, id)
+			}
+		}
+		clientLimiters.Unlock()
+	}
+}
+
+func initClients() {
+	if *apikeys != "" {
+		var err error
+		apiKeys, err = loadAPIKeys(*apikeys)
+		if err != nil {
+			logFatal.Fatalf("Error loading API keys (%s): %v\n", *apikeys, err)
+		}
+		logInfo.Printf("Loaded %d API keys from %s", len(apiKeys), *apikeys)
+	}
+	go sweepClients()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// flightKey identifies aggregations that can be shared, TimeGate aggregations only by lookups of the same datetime
+type flightKey struct {
+	URIR     string
+	TimeGate bool
+	Datetime int64
+	Wait     time.Duration
+}
+
+// flight is an in-flight aggregation shared by the lookups waiting for it, running in a session of its own
+type flight struct {
+	sess     *Session
+	done     chan struct{}
+	basetm   *list.List
+	timedOut []string
+	skipped  []string
+	waiters  int
+	cancel   context.CancelFunc
+}
+
+var flights = struct {
+	sync.Mutex
+	calls map[flightKey]*flight
+	gates map[flightKey]int
+}{calls: make(map[flightKey]*flight), gates: make(map[flightKey]int)}
+
+// remove takes the flight out of the table, unless it was replaced already, flights.Mutex being held
+func (f *flight) remove(key flightKey) {
+	if flights.calls[key] != f {
+		return
+	}
+	delete(flights.calls, key)
+	if key.TimeGate {
+		key.TimeGate, key.Datetime = false, 0
+		if flights.gates[key]--; flights.gates[key] <= 0 {
+			delete(flights.gates, key)
+		}
+	}
+}
+
+// run aggregates the TimeMap and caches it if complete, even if the lookup that started the flight is gone
+func (f *flight) run(ctx context.Context, key flightKey, urir string, dttmp *time.Time) {
+	defer f.cancel()
+	defer benchmarker("SESSION", "flight", "Complete session", f.sess.Start, f.sess)
+	f.basetm = aggregateTimemap(ctx, urir, dttmp, f.sess)
+	f.timedOut = f.sess.TimedOut
+	f.skipped = f.sess.Skipped
+	if !key.TimeGate && f.basetm.Len() > 0 && ctx.Err() == nil && !f.sess.partial() {
+		storeTimemap(urir, f.basetm)
+	}
+	flights.Lock()
+	f.remove(key)
+	flights.Unlock()
+	close(f.done)
+}
+
+// leave gives up waiting for the flight, cancelling it if nobody else is waiting
+func (f *flight) leave(key flightKey) {
+	flights.Lock()
+	defer flights.Unlock()
+	f.waiters--
+	if f.waiters == 0 {
+		f.remove(key)
+		f.cancel()
+	}
+}
+
+// coalesceTimemap shares one in-flight aggregation among concurrent lookups of the same URI-R
+func coalesceTimemap(ctx context.Context, urir string, dttmp *time.Time, sess *Session) (basetm *list.List) {
+	start := time.Now()
+	key := flightKey{URIR: cacheKey(urir)}
+	if !sess.Deadline.IsZero() {
+		key.Wait = sess.Deadline.Sub(sess.Start)
+	}
+	flights.Lock()
+	f, shared := flights.calls[key]
+	if !shared && dttmp != nil {
+		// A TimeGate flight serves one datetime, so a TimeMap flight is shared when caching or when the URI-R is already in flight
+		gkey := key
+		gkey.TimeGate = true
+		gkey.Datetime = dttmp.Unix()
+		f, shared = flights.calls[gkey]
+		if shared || (tmCache == nil && tmDisk == nil && flights.gates[key] == 0) {
+			key = gkey
+		}
+	}
+	if !shared {
+		fctx, cancel := context.WithCancel(context.Background())
+		f = &flight{
+			sess:   &Session{Start: start, Deadline: sess.Deadline},
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		flights.calls[key] = f
+		var fdttmp *time.Time
+		if key.TimeGate {
+			fdttmp = dttmp
+			flights.gates[flightKey{URIR: key.URIR, Wait: key.Wait}]++
+		}
+		go f.run(fctx, key, urir, fdttmp)
+	}
+	f.waiters++
+	flights.Unlock()
+	if shared {
+		logInfo.Printf("Joined in-flight aggregation of %s", urir)
+	}
+	return f.wait(ctx, key, shared, start, sess)
+}
+
+// joinTimemap waits for the in-flight TimeMap aggregation of the URI-R, if there is one, without starting a new one
+func joinTimemap(ctx context.Context, urir string, sess *Session) (basetm *list.List, ok bool) {
+	start := time.Now()
+	key := flightKey{URIR: cacheKey(urir)}
+	if !sess.Deadline.IsZero() {
+		key.Wait = sess.Deadline.Sub(sess.Start)
+	}
+	flights.Lock()
+	f, ok := flights.calls[key]
+	if !ok {
+		flights.Unlock()
+		return
+	}
+	f.waiters++
+	flights.Unlock()
+	logInfo.Printf("Joined in-flight aggregation of %s", urir)
+	return f.wait(ctx, key, true, start, sess), true
+}
+
+// wait returns a copy of the TimeMap of the flight once it lands, or an empty one if the context is done first
+func (f *flight) wait(ctx context.Context, key flightKey, shared bool, start time.Time, sess *Session) *list.List {
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		f.leave(key)
+		return list.New()
+	}
+	sess.TimedOut = append([]string(nil), f.timedOut...)
+	sess.Skipped = append([]string(nil), f.skipped...)
+	verb := "started"
+	if shared {
+		verb = "joined"
+	}
+	benchmarker("AGGREGATOR", "coalesce", fmt.Sprintf("%d Mementos from %s aggregation session %d", f.basetm.Len(), verb, f.sess.Start.UnixNano()), start, sess)
+	return copyTimemap(f.basetm)
+}
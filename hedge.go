@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errHedgeSkipped is the result of a hedged request that was not sent because the archive had no free slot or rate limit token
+var errHedgeSkipped = errors.New("hedged request skipped")
+
+type hedgeResult struct {
+	res *http.Response
+	err error
+	idx int
+}
+
+// releaseBody gives back the in-flight slot of a hedged request once its response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    *sync.Once
+}
+
+func (rb releaseBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.once.Do(rb.release)
+	return err
+}
+
+// hedge sends a second TimeGate request if the first is not answered within the p95 TimeGate latency, the first response winning
+func (arch *Archive) hedge(req *http.Request, sess *Session) (*http.Response, error) {
+	p95, ok := timegatePercentile(arch.ID, 0.95)
+	if !ok {
+		return arch.transport.RoundTrip(req)
+	}
+	results := make(chan hedgeResult, 2)
+	cancels := make([]context.CancelFunc, 0, 2)
+	start := time.Now()
+	launch := func(hedged bool) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels = append(cancels, cancel)
+		idx := len(cancels) - 1
+		go func() {
+			if !hedged {
+				res, err := arch.transport.RoundTrip(req.Clone(ctx))
+				results <- hedgeResult{res, err, idx}
+				return
+			}
+			if !arch.acquire(ctx) {
+				logInfo.Printf("%s => Hedged request skipped: %d requests already in flight", arch.ID, arch.MaxInFlight)
+				results <- hedgeResult{nil, errHedgeSkipped, idx}
+				return
+			}
+			if !arch.throttle(ctx) {
+				arch.release()
+				logInfo.Printf("%s => Hedged request skipped: rate limit reached", arch.ID)
+				results <- hedgeResult{nil, errHedgeSkipped, idx}
+				return
+			}
+			benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Hedged request sent to %s after %s", arch.Name, p95.Round(time.Millisecond)), start, sess)
+			logInfo.Printf("%s => Hedged request sent after p95 TimeGate latency of %s", arch.ID, p95)
+			res, err := arch.transport.RoundTrip(req.Clone(ctx))
+			if err != nil {
+				arch.release()
+			} else {
+				res.Body = releaseBody{res.Body, arch.release, new(sync.Once)}
+			}
+			results <- hedgeResult{res, err, idx}
+		}()
+	}
+	launch(false)
+	timer := time.NewTimer(p95)
+	defer timer.Stop()
+	var first hedgeResult
+	consumed := 1
+	select {
+	case first = <-results:
+	case <-timer.C:
+		launch(true)
+		first = <-results
+		if first.err != nil {
+			second := <-results
+			consumed++
+			if second.err == nil || errors.Is(first.err, errHedgeSkipped) {
+				first = second
+			}
+		}
+	}
+	for i, cancel := range cancels {
+		if i != first.idx {
+			cancel()
+		}
+	}
+	if pending := len(cancels) - consumed; pending > 0 {
+		go func() {
+			for ; pending > 0; pending-- {
+				if r := <-results; r.res != nil {
+					io.Copy(io.Discard, r.res.Body)
+					r.res.Body.Close()
+				}
+			}
+		}()
+	}
+	return first.res, first.err
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"container/list"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,11 +14,14 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"sort"
 	"sse"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -40,6 +44,7 @@ const (
 const (
 	responseFormats = "link|json|cdxj"
 	validDatetimes  = "YYYY[MM[DD[hh[mm[ss]]]]]"
+	shutdownTimeout = 5 * time.Second
 )
 
 var (
@@ -47,8 +52,8 @@ var (
 	logInfo      *log.Logger
 	logError     *log.Logger
 	logFatal     *log.Logger
-	transport    http.Transport
-	client       http.Client
+	transport    *http.Transport
+	client       *http.Client
 	broker       *sse.Broker
 	reverseProxy *httputil.ReverseProxy
 	baseURL      string
@@ -65,7 +70,7 @@ var proxy = flag.String([]string{"P", "-proxy"}, "http://{HOST}[:{PORT}]{ROOT}",
 var root = flag.String([]string{"R", "-root"}, "/", "Service root path prefix")
 var static = flag.String([]string{"D", "-static"}, "", "Directory path to serve static assets from")
 var port = flag.Int([]string{"p", "-port"}, 1208, "Port number - only used in web service mode")
-var topk = flag.Int([]string{"k", "-topk"}, -1, "Aggregate only top k archives based on probability")
+var topk = flag.Int([]string{"k", "-topk"}, -1, "Aggregate only top k archives based on probability or profile score")
 var tolerance = flag.Int([]string{"F", "-tolerance"}, -1, "Failure tolerance limit for each archive")
 var verbose = flag.Bool([]string{"V", "-verbose"}, false, "Show Info and Profiling messages on STDERR")
 var version = flag.Bool([]string{"v", "-version"}, false, "Show name and version")
@@ -74,27 +79,99 @@ var monitor = flag.Bool([]string{"m", "-monitor"}, false, "Benchmark monitoring
 var contimeout = flag.Duration([]string{"t", "-contimeout"}, time.Duration(5*time.Second), "Connection timeout for each archive")
 var hdrtimeout = flag.Duration([]string{"T", "-hdrtimeout"}, time.Duration(30*time.Second), "Header timeout for each archive")
 var restimeout = flag.Duration([]string{"r", "-restimeout"}, time.Duration(60*time.Second), "Response timeout for each archive")
+var deadline = flag.Duration([]string{"-deadline"}, time.Duration(0), "Aggregation deadline after which partial results are returned - 0 waits for all archives")
+var fastgate = flag.Duration([]string{"-fastgate"}, time.Duration(0), "Resolve TimeGates early once a Memento is this close to the requested datetime - 0 waits for all archives")
+var fastquorum = flag.Int([]string{"-fastquorum"}, 0, "Resolve TimeGates early once this many archives have returned Mementos - 0 waits for all archives")
 var dormant = flag.Duration([]string{"d", "-dormant"}, time.Duration(15*time.Minute), "Dormant period after consecutive failures")
+var retries = flag.Int([]string{"-retries"}, 0, "Retries of each archive request on connection resets and HTTP 502/503/504")
+var retrywait = flag.Duration([]string{"-retrywait"}, time.Duration(250*time.Millisecond), "Initial back-off before a retry, doubled and jittered for each further retry")
+var hedge = flag.Bool([]string{"-hedge"}, false, "Send a hedged TimeGate request to archives slower than their p95 TimeGate latency")
+var ratelimit = flag.Float64([]string{"-ratelimit"}, 0, "Maximum outbound requests per second to all archives combined - 0 disables the limit")
+var rateburst = flag.Int([]string{"-rateburst"}, 0, "Burst size of the outbound rate limit - defaults to one second worth of requests")
+var ratewait = flag.Duration([]string{"-ratewait"}, time.Duration(5*time.Second), "Longest wait for the rate limits before an archive is skipped")
+var failon = flag.String([]string{"-failon"}, "network", "Comma separated failures counted toward tolerance - network/5xx/429/parse/slow")
+var slowlimit = flag.Duration([]string{"-slowlimit"}, time.Duration(10*time.Second), "Response time beyond which a response is a slow failure")
+var maxdormant = flag.Duration([]string{"-maxdormant"}, time.Duration(4*time.Hour), "Maximum dormant period when probes keep failing")
+var cachesize = flag.Int([]string{"-cachesize"}, 0, "Maximum number of TimeMaps in the in-memory cache - 0 disables caching")
+var cachettl = flag.Duration([]string{"-cachettl"}, time.Duration(15*time.Minute), "Time to live of each cached TimeMap")
+var maxstale = flag.Duration([]string{"-maxstale"}, time.Duration(0), "Serve expired cached TimeMaps up to this long while refreshing them in the background - server mode only")
+var cachedir = flag.String([]string{"-cachedir"}, "", "Directory to persist cached TimeMaps in - shared by CLI and server")
+var arcsreload = flag.Duration([]string{"-arcsreload"}, time.Duration(0), "Interval to reload the list of archives - 0 disables polling, SIGHUP always reloads")
+var adaptive = flag.Bool([]string{"-adaptive"}, false, "Order archives by hit rates learned from live responses instead of probability")
+var statsfile = flag.String([]string{"-statsfile"}, "", "File to persist learned archive statistics in across restarts")
+var apikeys = flag.String([]string{"-apikeys"}, "", "File of API keys (one per line, optionally followed by a rate and a burst) required by the aggregation endpoints")
+var clientrate = flag.Float64([]string{"-clientrate"}, 0, "Maximum aggregation requests per second of each API key (or IP if no keys are used) - 0 disables the limit")
+var clientburst = flag.Int([]string{"-clientburst"}, 0, "Burst size of the client rate limit - defaults to one second worth of requests")
+var admintoken = flag.String([]string{"-admintoken"}, "", "Bearer token to access admin endpoints - empty disables them")
 
 // Session struct needs explanation, TODO
 type Session struct {
-	Start time.Time
+	Start    time.Time
+	Cached   time.Time
+	Stale    bool
+	Deadline time.Time
+	TimedOut []string
+	Skipped  []string
+	mu       sync.Mutex
+	progress chan ProgressEvent
+}
+
+func newSession(start time.Time) *Session {
+	sess := &Session{Start: start}
+	if *deadline > 0 {
+		sess.Deadline = start.Add(*deadline)
+	}
+	return sess
+}
+
+func (sess *Session) timedOut(id string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.TimedOut = append(sess.TimedOut, id)
+}
+
+// skipped records an archive left out of the aggregation by its breaker or its limits
+func (sess *Session) skipped(id string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.Skipped = append(sess.Skipped, id)
+}
+
+// partial tells whether some archives timed out or were skipped, in which case the TimeMap is not cached
+func (sess *Session) partial() bool {
+	return len(sess.TimedOut) > 0 || len(sess.Skipped) > 0
 }
 
 // Archive struct needs explanation, TODO
 type Archive struct {
-	ID          string  `json:"id"`
-	Name        string  `json:"name"`
-	Timemap     string  `json:"timemap"`
-	Timegate    string  `json:"timegate"`
-	Probability float64 `json:"probability"`
-	Ignore      bool    `json:"ignore"`
-	Dormant     bool    `json:"-"`
-	Failures    int     `json:"-"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Timemap     string            `json:"timemap"`
+	Timegate    string            `json:"timegate"`
+	Probability float64           `json:"probability"`
+	Ignore      bool              `json:"ignore"`
+	ConTimeout  Duration          `json:"contimeout,omitempty"`
+	HdrTimeout  Duration          `json:"hdrtimeout,omitempty"`
+	ResTimeout  Duration          `json:"restimeout,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Auth        *ArchiveAuth      `json:"auth,omitempty"`
+	Include     *URIRules         `json:"include,omitempty"`
+	Exclude     *URIRules         `json:"exclude,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
+	MaxInFlight int               `json:"maxinflight,omitempty"`
+	Retries     int               `json:"retries,omitempty"`
+	RateLimit   float64           `json:"ratelimit,omitempty"`
+	RateBurst   int               `json:"rateburst,omitempty"`
+	breaker     *Breaker
+	transport   http.RoundTripper
+	client      *http.Client
+	slots       chan struct{}
+	profile     *Profile
+	limiter     *TokenBucket
 }
 
 // Archives struct needs explanation, TODO
-type Archives []Archive
+type Archives []*Archive
 
 func (a Archives) Len() int {
 	return len(a)
@@ -131,6 +208,13 @@ func (a *Archives) sanitize() {
 }
 
 var archives Archives
+var archivesMu sync.RWMutex
+
+func currentArchives() Archives {
+	archivesMu.RLock()
+	defer archivesMu.RUnlock()
+	return archives
+}
 
 // Link struct needs explanation, TODO
 type Link struct {
@@ -138,6 +222,7 @@ type Link struct {
 	Datetime string
 	Timeobj  time.Time
 	Timestr  string
+	Archive  string
 	NavRels  []string
 }
 
@@ -153,10 +238,13 @@ var regs = map[string]*regexp.Regexp{
 	"attrdlm": regexp.MustCompile(`\s*>?"?\s*;\s*`),
 	"kvaldlm": regexp.MustCompile(`\s*=\s*"?\s*`),
 	"memento": regexp.MustCompile(`\bmemento\b`),
+	"lnkfrmt": regexp.MustCompile(`^\s*<[^>]*>\s*;`),
 	"memdttm": regexp.MustCompile(`/(\d{14})/`),
 	"dttmstr": regexp.MustCompile(`^(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?$`),
 	"tmappth": regexp.MustCompile(`^timemap/(link|json|cdxj)/.+`),
 	"tgatpth": regexp.MustCompile(`^timegate/.+`),
+	"strmpth": regexp.MustCompile(`^stream/(json|cdxj)/.+`),
+	"progpth": regexp.MustCompile(`^progress/(link|json|cdxj)/.+`),
 	"descpth": regexp.MustCompile(`^(memento|api)/(link|json|cdxj|proxy)/(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?/.+`),
 	"rdrcpth": regexp.MustCompile(`^memento/(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?/.+`),
 }
@@ -178,13 +266,39 @@ func readArchives() (body []byte, err error) {
 	}
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
-		err = fmt.Errorf(res.Status)
+		err = fmt.Errorf("%s", res.Status)
 		return
 	}
 	body, err = io.ReadAll(res.Body)
 	return
 }
 
+func loadArchives() (arcs Archives, err error) {
+	body, err := readArchives()
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(body, &arcs)
+	if err != nil {
+		return
+	}
+	arcs.sanitize()
+	arcs.filterIgnored()
+	sort.Sort(arcs)
+	err = arcs.initRules()
+	if err != nil {
+		return
+	}
+	err = arcs.initProfiles()
+	if err != nil {
+		return
+	}
+	arcs.initBreakers()
+	arcs.initLimiters()
+	err = arcs.initNetwork()
+	return
+}
+
 func splitLinks(lnkrcvd chan string, lnksplt chan string) {
 	defer close(lnksplt)
 	lnkstr := <-lnkrcvd
@@ -213,7 +327,7 @@ func splitLinks(lnkrcvd chan string, lnksplt chan string) {
 	}
 }
 
-func extractMementos(lnksplt chan string) (tml *list.List) {
+func extractMementos(lnksplt chan string, archid string) (tml *list.List) {
 	tml = list.New()
 	for lnk := range lnksplt {
 		lnk = strings.Trim(lnk, "<\" \t\n\r")
@@ -246,6 +360,7 @@ func extractMementos(lnksplt chan string) (tml *list.List) {
 			Datetime: dtm,
 			Timeobj:  pdtm,
 			Timestr:  pdtm.Format("20060102150405"),
+			Archive:  archid,
 		}
 		e := tml.Back()
 		for ; e != nil; e = e.Prev() {
@@ -261,16 +376,18 @@ func extractMementos(lnksplt chan string) (tml *list.List) {
 	return
 }
 
-func fetchTimemap(urir string, arch *Archive, tmCh chan *list.List, wg *sync.WaitGroup, dttmp *time.Time, sess *Session) {
+func fetchTimemap(ctx context.Context, urir string, arch *Archive, tmCh chan *list.List, wg *sync.WaitGroup, dttmp *time.Time, sess *Session) {
 	start := time.Now()
 	defer wg.Done()
 	url := arch.Timemap + urir
 	if dttmp != nil {
 		url = arch.Timegate + urir
 	}
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
+		arch.breaker.Cancel()
 		benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Request error in %s", arch.Name), start, sess)
+		sess.report("failed", arch, 0, "Request error")
 		logError.Printf("%s => Request error: %v", arch.ID, err)
 		return
 	}
@@ -280,69 +397,152 @@ func fetchTimemap(urir string, arch *Archive, tmCh chan *list.List, wg *sync.Wai
 	} else {
 		req.Header.Add("User-Agent", *agent)
 	}
-	var res *http.Response
-	if dttmp == nil {
-		res, err = client.Do(req)
-	} else {
+	if !arch.throttle(ctx) {
+		arch.breaker.Cancel()
+		sess.skipped(arch.ID)
+		benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Rate limit reached in %s", arch.Name), start, sess)
+		sess.report("skipped", arch, 0, "Rate limit reached")
+		logInfo.Printf("%s => Skipped: rate limit reached", arch.ID)
+		return
+	}
+	if !arch.acquire(ctx) {
+		arch.breaker.Cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			sess.timedOut(arch.ID)
+		} else if ctx.Err() == nil {
+			sess.skipped(arch.ID)
+		}
+		benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("In-flight limit reached in %s", arch.Name), start, sess)
+		sess.report("skipped", arch, 0, "In-flight limit reached")
+		logInfo.Printf("%s => Skipped: %d requests already in flight", arch.ID, arch.MaxInFlight)
+		return
+	}
+	defer arch.release()
+	if dttmp != nil {
 		req.Header.Add("Accept-Datetime", dttmp.Format(http.TimeFormat))
-		res, err = transport.RoundTrip(req)
+	}
+	sess.report("started", arch, 0, "")
+	sent := time.Now()
+	res, err := arch.send(req, dttmp != nil, sess)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		arch.breaker.Cancel()
+		sess.timedOut(arch.ID)
+		benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Aggregation deadline passed in %s", arch.Name), start, sess)
+		sess.report("timedout", arch, 0, "Aggregation deadline passed")
+		recordArchive(arch.ID, "timeout", time.Since(sent), 0)
+		logInfo.Printf("%s => Timed out: aggregation deadline passed", arch.ID)
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		arch.breaker.Cancel()
+		benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Request cancelled in %s", arch.Name), start, sess)
+		logInfo.Printf("%s => Cancelled: %v", arch.ID, ctx.Err())
+		return
 	}
 	if err != nil {
 		benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Network error in %s", arch.Name), start, sess)
+		sess.report("failed", arch, 0, "Network error")
+		recordArchive(arch.ID, "network_error", time.Since(sent), 0)
 		logError.Printf("%s => Network error: %v", arch.ID, err)
-		arch.Failures++
-		if arch.Failures == *tolerance {
-			arch.Dormant = true
-			logInfo.Printf("%s => Dormant after %d consecutive failures", arch.ID, arch.Failures)
-			go func(arch *Archive) {
-				time.Sleep(*dormant)
-				arch.Dormant = false
-				arch.Failures = 0
-				logInfo.Printf("%s => Awake after %s", arch.ID, *dormant)
-			}(arch)
-		}
+		learnArchive(arch, false, time.Since(sent))
+		arch.breaker.Failure(0)
 		return
 	}
-	arch.Failures = 0
 	defer res.Body.Close()
+	if dttmp != nil {
+		learnTimegate(arch.ID, time.Since(sent))
+	}
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusFound {
 		benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Response error in %s, Status: %d", arch.Name, res.StatusCode), start, sess)
+		sess.report("failed", arch, 0, "Response error: "+res.Status)
+		recordArchive(arch.ID, "response_error", time.Since(sent), 0)
 		logInfo.Printf("%s => Response error: %s", arch.ID, res.Status)
+		learnArchive(arch, false, time.Since(sent))
+		if class := statusFailure(res.StatusCode); failsOn(class) {
+			logInfo.Printf("%s => Counted as failure (%s)", arch.ID, class)
+			arch.breaker.Failure(retryAfter(res))
+		} else {
+			arch.breaker.Success()
+		}
 		return
 	}
 	lnks := res.Header.Get("Link")
 	if dttmp == nil {
 		body, err := io.ReadAll(res.Body)
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			arch.breaker.Cancel()
+			sess.timedOut(arch.ID)
+			benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Aggregation deadline passed in %s", arch.Name), start, sess)
+			sess.report("timedout", arch, 0, "Aggregation deadline passed")
+			recordArchive(arch.ID, "timeout", time.Since(sent), 0)
+			logInfo.Printf("%s => Timed out: aggregation deadline passed", arch.ID)
+			return
+		}
+		if err != nil && ctx.Err() != nil {
+			arch.breaker.Cancel()
+			benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Response read cancelled in %s", arch.Name), start, sess)
+			logInfo.Printf("%s => Cancelled: %v", arch.ID, ctx.Err())
+			return
+		}
 		if err != nil {
 			benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Response read error in %s", arch.Name), start, sess)
+			sess.report("failed", arch, 0, "Response read error")
+			recordArchive(arch.ID, "network_error", time.Since(sent), 0)
 			logError.Printf("%s => Response read error: %v", arch.ID, err)
+			learnArchive(arch, false, time.Since(sent))
+			arch.breaker.Failure(0)
 			return
 		}
 		lnks = string(body)
 	}
+	latency := time.Since(sent)
 	benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("TimeMap fetched from %s", arch.Name), start, sess)
 	start = time.Now()
 	lnkrcvd := make(chan string, 1)
 	lnksplt := make(chan string, 128)
 	lnkrcvd <- lnks
 	go splitLinks(lnkrcvd, lnksplt)
-	tml := extractMementos(lnksplt)
+	tml := extractMementos(lnksplt, arch.ID)
+	learnArchive(arch, tml.Len() > 0, latency)
+	class := responseFailure(lnks, tml.Len(), latency)
+	if class == "parse" {
+		recordArchive(arch.ID, "parse_error", latency, 0)
+	} else {
+		recordArchive(arch.ID, "success", latency, tml.Len())
+	}
+	if failsOn(class) {
+		logInfo.Printf("%s => Counted as failure (%s)", arch.ID, class)
+		arch.breaker.Failure(0)
+	} else {
+		arch.breaker.Success()
+	}
+	count := tml.Len()
 	tmCh <- tml
-	benchmarker(arch.ID, "extractmementos", fmt.Sprintf("%d Mementos extracted from %s", tml.Len(), arch.Name), start, sess)
-	logInfo.Printf("%s => Success: %d mementos", arch.ID, tml.Len())
+	sess.report("mementos", arch, count, "")
+	benchmarker(arch.ID, "extractmementos", fmt.Sprintf("%d Mementos extracted from %s", count, arch.Name), start, sess)
+	logInfo.Printf("%s => Success: %d mementos", arch.ID, count)
 }
 
-func serializeLinks(urir string, basetm *list.List, format string, dataCh chan string, navonly bool, sess *Session) {
+func serializeLinks(ctx context.Context, urir string, basetm *list.List, format string, dataCh chan string, navonly bool, sess *Session) {
 	start := time.Now()
 	defer benchmarker("AGGREGATOR", "serialize", fmt.Sprintf("%d mementos serialized", basetm.Len()), start, sess)
+	defer func() {
+		observeSerialization(time.Since(start))
+	}()
 	defer close(dataCh)
+	send := func(data string) {
+		select {
+		case dataCh <- data:
+		case <-ctx.Done():
+		}
+	}
 	switch strings.ToLower(format) {
 	case "link":
-		dataCh <- fmt.Sprintf(`<%s>; rel="original",`+"\n", urir)
+		send(fmt.Sprintf(`<%s>; rel="original",`+"\n", urir))
 		if !navonly {
-			dataCh <- fmt.Sprintf(`<%s/timemap/link/%s>; rel="self"; type="application/link-format",`+"\n", *proxy, urir)
+			send(fmt.Sprintf(`<%s/timemap/link/%s>; rel="self"; type="application/link-format",`+"\n", *proxy, urir))
 		}
-		for e := basetm.Front(); e != nil; e = e.Next() {
+		for e := basetm.Front(); e != nil && ctx.Err() == nil; e = e.Next() {
 			lnk := e.Value.(Link)
 			if navonly && lnk.NavRels == nil {
 				continue
@@ -352,23 +552,29 @@ func serializeLinks(urir string, basetm *list.List, format string, dataCh chan s
 				rels = strings.Join(lnk.NavRels, " ") + " " + rels
 				rels = strings.Replace(rels, "closest ", "", -1)
 			}
-			dataCh <- fmt.Sprintf(`<%s>; rel="%s"; datetime="%s",`+"\n", lnk.Href, rels, lnk.Datetime)
+			send(fmt.Sprintf(`<%s>; rel="%s"; datetime="%s",`+"\n", lnk.Href, rels, lnk.Datetime))
 		}
-		dataCh <- fmt.Sprintf(`<%s/timemap/link/%s>; rel="timemap"; type="application/link-format",`+"\n", *proxy, urir)
-		dataCh <- fmt.Sprintf(`<%s/timemap/json/%s>; rel="timemap"; type="application/json",`+"\n", *proxy, urir)
-		dataCh <- fmt.Sprintf(`<%s/timemap/cdxj/%s>; rel="timemap"; type="application/cdxj+ors",`+"\n", *proxy, urir)
-		dataCh <- fmt.Sprintf(`<%s/timegate/%s>; rel="timegate"`+"\n", *proxy, urir)
+		send(fmt.Sprintf(`<%s/timemap/link/%s>; rel="timemap"; type="application/link-format",`+"\n", *proxy, urir))
+		send(fmt.Sprintf(`<%s/timemap/json/%s>; rel="timemap"; type="application/json",`+"\n", *proxy, urir))
+		send(fmt.Sprintf(`<%s/timemap/cdxj/%s>; rel="timemap"; type="application/cdxj+ors",`+"\n", *proxy, urir))
+		send(fmt.Sprintf(`<%s/timegate/%s>; rel="timegate"`+"\n", *proxy, urir))
 	case "json":
-		dataCh <- fmt.Sprintf("{\n"+`  "original_uri": "%s",`+"\n", urir)
+		send(fmt.Sprintf("{\n"+`  "original_uri": "%s",`+"\n", urir))
+		if len(sess.TimedOut) > 0 {
+			send(fmt.Sprintf(`  "timedout_archives": ["%s"],`+"\n", strings.Join(sess.TimedOut, `", "`)))
+		}
+		if len(sess.Skipped) > 0 {
+			send(fmt.Sprintf(`  "skipped_archives": ["%s"],`+"\n", strings.Join(sess.Skipped, `", "`)))
+		}
 		if !navonly {
-			dataCh <- fmt.Sprintf(`  "self": "%s/timemap/json/%s",`+"\n", *proxy, urir)
+			send(fmt.Sprintf(`  "self": "%s/timemap/json/%s",`+"\n", *proxy, urir))
 		}
-		dataCh <- fmt.Sprintf(`  "mementos": {` + "\n")
+		send(fmt.Sprintf(`  "mementos": {` + "\n"))
 		if !navonly {
-			dataCh <- `    "list": [` + "\n"
+			send(`    "list": [` + "\n")
 		}
 		navs := ""
-		for e := basetm.Front(); e != nil; e = e.Next() {
+		for e := basetm.Front(); e != nil && ctx.Err() == nil; e = e.Next() {
 			lnk := e.Value.(Link)
 			if navonly && lnk.NavRels == nil {
 				continue
@@ -379,31 +585,37 @@ func serializeLinks(urir string, basetm *list.List, format string, dataCh chan s
 				}
 			}
 			if !navonly {
-				dataCh <- fmt.Sprintf(`      {`+"\n"+`        "datetime": "%s",`+"\n"+`        "uri": "%s"`+"\n      }", lnk.Timeobj.Format(time.RFC3339), lnk.Href)
+				send(fmt.Sprintf(`      {`+"\n"+`        "datetime": "%s",`+"\n"+`        "uri": "%s"`+"\n      }", lnk.Timeobj.Format(time.RFC3339), lnk.Href))
 				if e.Next() != nil {
-					dataCh <- ",\n"
+					send(",\n")
 				}
 			}
 		}
 		if !navonly {
-			dataCh <- "\n    ],\n"
-		}
-		dataCh <- strings.TrimRight(navs, ",\n")
-		dataCh <- fmt.Sprintf("\n  },\n" + `  "timemap_uri": {` + "\n")
-		dataCh <- fmt.Sprintf(`    "link_format": "%s/timemap/link/%s",`+"\n", *proxy, urir)
-		dataCh <- fmt.Sprintf(`    "json_format": "%s/timemap/json/%s",`+"\n", *proxy, urir)
-		dataCh <- fmt.Sprintf(`    "cdxj_format": "%s/timemap/cdxj/%s"`+"\n  },\n", *proxy, urir)
-		dataCh <- fmt.Sprintf(`  "timegate_uri": "%s/timegate/%s"`+"\n}\n", *proxy, urir)
+			send("\n    ],\n")
+		}
+		send(strings.TrimRight(navs, ",\n"))
+		send(fmt.Sprintf("\n  },\n" + `  "timemap_uri": {` + "\n"))
+		send(fmt.Sprintf(`    "link_format": "%s/timemap/link/%s",`+"\n", *proxy, urir))
+		send(fmt.Sprintf(`    "json_format": "%s/timemap/json/%s",`+"\n", *proxy, urir))
+		send(fmt.Sprintf(`    "cdxj_format": "%s/timemap/cdxj/%s"`+"\n  },\n", *proxy, urir))
+		send(fmt.Sprintf(`  "timegate_uri": "%s/timegate/%s"`+"\n}\n", *proxy, urir))
 	case "cdxj":
-		dataCh <- fmt.Sprintf(`!context ["https://oduwsdl.github.io/contexts/memento"]` + "\n")
+		send(fmt.Sprintf(`!context ["https://oduwsdl.github.io/contexts/memento"]` + "\n"))
 		if !navonly {
-			dataCh <- fmt.Sprintf(`!id {"uri": "%s/timemap/cdxj/%s"}`+"\n", *proxy, urir)
+			send(fmt.Sprintf(`!id {"uri": "%s/timemap/cdxj/%s"}`+"\n", *proxy, urir))
+		}
+		send(fmt.Sprintf(`!keys ["memento_datetime_YYYYMMDDhhmmss"]` + "\n"))
+		send(fmt.Sprintf(`!meta {"original_uri": "%s"}`+"\n", urir))
+		if len(sess.TimedOut) > 0 {
+			send(fmt.Sprintf(`!meta {"timedout_archives": ["%s"]}`+"\n", strings.Join(sess.TimedOut, `", "`)))
+		}
+		if len(sess.Skipped) > 0 {
+			send(fmt.Sprintf(`!meta {"skipped_archives": ["%s"]}`+"\n", strings.Join(sess.Skipped, `", "`)))
 		}
-		dataCh <- fmt.Sprintf(`!keys ["memento_datetime_YYYYMMDDhhmmss"]` + "\n")
-		dataCh <- fmt.Sprintf(`!meta {"original_uri": "%s"}`+"\n", urir)
-		dataCh <- fmt.Sprintf(`!meta {"timegate_uri": "%s/timegate/%s"}`+"\n", *proxy, urir)
-		dataCh <- fmt.Sprintf(`!meta {"timemap_uri": {"link_format": "%s/timemap/link/%s", "json_format": "%s/timemap/json/%s", "cdxj_format": "%s/timemap/cdxj/%s"}}`+"\n", *proxy, urir, *proxy, urir, *proxy, urir)
-		for e := basetm.Front(); e != nil; e = e.Next() {
+		send(fmt.Sprintf(`!meta {"timegate_uri": "%s/timegate/%s"}`+"\n", *proxy, urir))
+		send(fmt.Sprintf(`!meta {"timemap_uri": {"link_format": "%s/timemap/link/%s", "json_format": "%s/timemap/json/%s", "cdxj_format": "%s/timemap/cdxj/%s"}}`+"\n", *proxy, urir, *proxy, urir, *proxy, urir))
+		for e := basetm.Front(); e != nil && ctx.Err() == nil; e = e.Next() {
 			lnk := e.Value.(Link)
 			if navonly && lnk.NavRels == nil {
 				continue
@@ -412,65 +624,136 @@ func serializeLinks(urir string, basetm *list.List, format string, dataCh chan s
 			if lnk.NavRels != nil {
 				rels = strings.Join(lnk.NavRels, " ") + " " + rels
 			}
-			dataCh <- fmt.Sprintf(`%s {"uri": "%s", "rel": "%s", "datetime": "%s"}`+"\n", lnk.Timestr, lnk.Href, rels, lnk.Datetime)
+			send(fmt.Sprintf(`%s {"uri": "%s", "rel": "%s", "datetime": "%s"}`+"\n", lnk.Timestr, lnk.Href, rels, lnk.Datetime))
 		}
 	default:
-		dataCh <- fmt.Sprintf("Unrecognized format: %s\n", format)
+		send(fmt.Sprintf("Unrecognized format: %s\n", format))
 	}
 }
 
-func aggregateTimemap(urir string, dttmp *time.Time, sess *Session) (basetm *list.List) {
+// fetchTimemaps queries the routed archives concurrently, sending the TimeMap of each archive that responds on the returned channel, which is closed once all archives are done
+func fetchTimemaps(ctx context.Context, urir string, dttmp *time.Time, sess *Session) chan *list.List {
 	var wg sync.WaitGroup
-	tmCh := make(chan *list.List, len(archives))
-	for i, arch := range archives {
+	start := time.Now()
+	cancel := func() {}
+	if !sess.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, sess.Deadline)
+	}
+	arcs := currentArchives().route(urir)
+	benchmarker("AGGREGATOR", "route", fmt.Sprintf("%d archives routed", len(arcs)), start, sess)
+	tmCh := make(chan *list.List, len(arcs))
+	for i, arch := range arcs {
 		if i == *topk {
 			break
 		}
-		if arch.Dormant {
+		if ctx.Err() != nil {
+			break
+		}
+		if !arch.breaker.Allow() {
+			sess.skipped(arch.ID)
+			sess.report("skipped", arch, 0, "Dormant")
 			continue
 		}
 		wg.Add(1)
-		go fetchTimemap(urir, &archives[i], tmCh, &wg, dttmp, sess)
+		go fetchTimemap(ctx, urir, arch, tmCh, &wg, dttmp, sess)
 	}
 	go func() {
 		wg.Wait()
+		cancel()
 		close(tmCh)
 	}()
+	return tmCh
+}
+
+// mergeTimemaps merges two TimeMaps sorted by datetime into the longer one, which it returns
+func mergeTimemaps(basetm *list.List, newtm *list.List) *list.List {
+	if newtm.Len() > basetm.Len() {
+		newtm, basetm = basetm, newtm
+	}
+	m := basetm.Back()
+	e := newtm.Back()
+	for e != nil {
+		if m != nil {
+			if e.Value.(Link).Timestr > m.Value.(Link).Timestr {
+				basetm.InsertAfter(e.Value, m)
+				e = e.Prev()
+			} else {
+				m = m.Prev()
+			}
+		} else {
+			for e != nil {
+				basetm.PushFront(e.Value)
+				e = e.Prev()
+			}
+		}
+	}
+	return basetm
+}
+
+func aggregateTimemap(ctx context.Context, urir string, dttmp *time.Time, sess *Session) (basetm *list.List) {
+	start := time.Now()
+	fast := dttmp != nil && (*fastgate > 0 || *fastquorum > 0)
+	ctx, settle := context.WithCancel(ctx)
+	defer settle()
+	tmCh := fetchTimemaps(ctx, urir, dttmp, sess)
 	basetm = list.New()
+	answered := 0
+	settled := false
 	for newtm := range tmCh {
 		start := time.Now()
-		if newtm.Len() == 0 {
+		if newtm.Len() == 0 || settled {
 			continue
 		}
+		answered++
 		if basetm.Len() == 0 {
 			basetm = newtm
-			continue
+		} else {
+			basetm = mergeTimemaps(basetm, newtm)
+			benchmarker("AGGREGATOR", "aggregate", fmt.Sprintf("%d Mementos accumulated and sorted", basetm.Len()), start, sess)
 		}
-		if newtm.Len() > basetm.Len() {
-			newtm, basetm = basetm, newtm
-		}
-		m := basetm.Back()
-		e := newtm.Back()
-		for e != nil {
-			if m != nil {
-				if e.Value.(Link).Timestr > m.Value.(Link).Timestr {
-					basetm.InsertAfter(e.Value, m)
-					e = e.Prev()
-				} else {
-					m = m.Prev()
-				}
-			} else {
-				for e != nil {
-					basetm.PushFront(e.Value)
-					e = e.Prev()
-				}
-			}
+		if fast && fastEnough(basetm, *dttmp, answered, sess) {
+			settled = true
+			settle()
 		}
-		benchmarker("AGGREGATOR", "aggregate", fmt.Sprintf("%d Mementos accumulated and sorted", basetm.Len()), start, sess)
+	}
+	observeAggregation(time.Since(start))
+	if len(sess.TimedOut) > 0 {
+		sort.Strings(sess.TimedOut)
+		benchmarker("AGGREGATOR", "deadline", fmt.Sprintf("%d archives timed out", len(sess.TimedOut)), start, sess)
+		logInfo.Printf("Partial TimeMap of %s, timed out: %s", urir, strings.Join(sess.TimedOut, ", "))
+	}
+	if len(sess.Skipped) > 0 {
+		sort.Strings(sess.Skipped)
+		logInfo.Printf("Partial TimeMap of %s, skipped: %s", urir, strings.Join(sess.Skipped, ", "))
 	}
 	return
 }
 
+// fastEnough tells whether a TimeGate can be resolved without waiting for the remaining archives, because enough of them have answered or a Memento is close enough to the requested datetime
+func fastEnough(basetm *list.List, dttm time.Time, answered int, sess *Session) bool {
+	start := time.Now()
+	if *fastquorum > 0 && answered >= *fastquorum {
+		benchmarker("AGGREGATOR", "fastgate", fmt.Sprintf("Resolved early after %d archives answered", answered), start, sess)
+		logInfo.Printf("TimeGate resolved early after %d archives answered", answered)
+		return true
+	}
+	if *fastgate <= 0 {
+		return false
+	}
+	for e := basetm.Front(); e != nil; e = e.Next() {
+		dur := e.Value.(Link).Timeobj.Sub(dttm)
+		if dur < 0 {
+			dur = -dur
+		}
+		if dur <= *fastgate {
+			benchmarker("AGGREGATOR", "fastgate", fmt.Sprintf("Resolved early with a Memento %s away", dur), start, sess)
+			logInfo.Printf("TimeGate resolved early with a Memento %s away", dur)
+			return true
+		}
+	}
+	return false
+}
+
 func parseURI(uri string) (urir string, err error) {
 	uescd, err := url.PathUnescape(uri)
 	if err != nil {
@@ -507,9 +790,12 @@ func benchmarker(origin string, role string, info string, start time.Time, sess
 	begin := sess.Start.UnixNano()
 	info += fmt.Sprintf(" - Duration: %v", end.Sub(start))
 	logBenchmark.Printf(`%d {"origin": "%s", "role": "%s", "info": "%s", "start": %d, "end": %d}`, begin, origin, role, info, start.UnixNano(), end.UnixNano())
-	if *monitor {
+	if *monitor && broker != nil {
 		event := fmt.Sprintf(`{"session": "%d", "origin": "%s", "role": "%s", "info": "%s", "start": %d, "end": %d}`, begin, origin, role, info, start.UnixNano(), end.UnixNano())
-		broker.Notifier <- []byte(event)
+		broker.Notify([]byte(event))
+		if summary, done := rollupEvent(begin, origin, role, info, end); done {
+			broker.Notify([]byte(summary))
+		}
 	}
 }
 
@@ -564,8 +850,9 @@ func setNavRels(basetm *list.List, dttmp *time.Time, sess *Session) (navonly boo
 
 func memgatorCli(urir string, format string, dttmp *time.Time) {
 	start := time.Now()
-	sess := new(Session)
-	sess.Start = start
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	sess := newSession(start)
 	upsession := "timemap"
 	if dttmp != nil {
 		upsession = "timegate"
@@ -573,13 +860,17 @@ func memgatorCli(urir string, format string, dttmp *time.Time) {
 	defer benchmarker("SESSION", upsession, "Complete session", start, sess)
 	benchmarker("AGGREGATOR", "createsess", "Session created", start, sess)
 	logInfo.Printf("Aggregating Mementos for %s", urir)
-	basetm := aggregateTimemap(urir, dttmp, sess)
+	basetm := lookupTimemap(ctx, urir, dttmp, sess)
+	if ctx.Err() != nil {
+		logInfo.Printf("Aggregation interrupted for %s", urir)
+		return
+	}
 	if basetm.Len() == 0 {
 		return
 	}
 	navonly, _ := setNavRels(basetm, dttmp, sess)
 	dataCh := make(chan string, 1)
-	go serializeLinks(urir, basetm, format, dataCh, navonly, sess)
+	go serializeLinks(ctx, urir, basetm, format, dataCh, navonly, sess)
 	for dt := range dataCh {
 		fmt.Print(dt)
 	}
@@ -588,8 +879,11 @@ func memgatorCli(urir string, format string, dttmp *time.Time) {
 
 func memgatorService(w http.ResponseWriter, r *http.Request, urir string, format string, dttmp *time.Time) {
 	start := time.Now()
-	sess := new(Session)
-	sess.Start = start
+	sess := newSession(start)
+	if wait, ok := preferWait(r); ok {
+		sess.Deadline = start.Add(wait)
+		w.Header().Set("Preference-Applied", fmt.Sprintf("wait=%d", int(wait.Seconds())))
+	}
 	upsession := "timemap"
 	if dttmp != nil {
 		upsession = "timegate"
@@ -597,9 +891,26 @@ func memgatorService(w http.ResponseWriter, r *http.Request, urir string, format
 	defer benchmarker("SESSION", upsession, "Complete session", start, sess)
 	benchmarker("AGGREGATOR", "createsess", "Session created", start, sess)
 	logInfo.Printf("Aggregating Mementos for %s", urir)
-	basetm := aggregateTimemap(urir, dttmp, sess)
+	ctx := r.Context()
+	basetm := lookupTimemap(ctx, urir, dttmp, sess)
+	if ctx.Err() != nil {
+		logInfo.Printf("Client disconnected while aggregating %s", urir)
+		return
+	}
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Link, Location, X-Memento-Count, Server")
+	w.Header().Set("Access-Control-Expose-Headers", "Link, Location, X-Memento-Count, X-Timedout-Archives, X-Skipped-Archives, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Server, Age, Warning")
+	if !sess.Cached.IsZero() {
+		w.Header().Set("Age", fmt.Sprintf("%d", int(time.Since(sess.Cached).Seconds())))
+		if sess.Stale {
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+		}
+	}
+	if len(sess.TimedOut) > 0 {
+		w.Header().Set("X-Timedout-Archives", strings.Join(sess.TimedOut, ", "))
+	}
+	if len(sess.Skipped) > 0 {
+		w.Header().Set("X-Skipped-Archives", strings.Join(sess.Skipped, ", "))
+	}
 	if dttmp == nil {
 		w.Header().Set("X-Memento-Count", fmt.Sprintf("%d", basetm.Len()))
 	}
@@ -613,7 +924,7 @@ func memgatorService(w http.ResponseWriter, r *http.Request, urir string, format
 		return
 	}
 	if format == "proxy" {
-		nr, err := http.NewRequest(http.MethodGet, closest, nil)
+		nr, err := http.NewRequestWithContext(ctx, http.MethodGet, closest, nil)
 		if err != nil {
 			logError.Printf("Error creating proxy request (%s): %v", closest, err)
 			http.Error(w, "Error creating proxy request for "+closest, http.StatusInternalServerError)
@@ -625,7 +936,7 @@ func memgatorService(w http.ResponseWriter, r *http.Request, urir string, format
 	}
 	dataCh := make(chan string, 1)
 	if format == "timegate" {
-		go serializeLinks(urir, basetm, "link", dataCh, navonly, sess)
+		go serializeLinks(ctx, urir, basetm, "link", dataCh, navonly, sess)
 		lnkhdr := ""
 		for dt := range dataCh {
 			lnkhdr += dt
@@ -636,7 +947,7 @@ func memgatorService(w http.ResponseWriter, r *http.Request, urir string, format
 		http.Redirect(w, r, closest, http.StatusFound)
 		return
 	}
-	go serializeLinks(urir, basetm, format, dataCh, navonly, sess)
+	go serializeLinks(ctx, urir, basetm, format, dataCh, navonly, sess)
 	mime, ok := mimeMap[strings.ToLower(format)]
 	if ok {
 		w.Header().Set("Content-Type", mime)
@@ -647,6 +958,22 @@ func memgatorService(w http.ResponseWriter, r *http.Request, urir string, format
 	logInfo.Printf("Total Mementos: %d in %s", basetm.Len(), time.Since(start))
 }
 
+// preferWait reads the aggregation deadline of a request from the wait preference (in seconds) of its Prefer header
+func preferWait(r *http.Request) (wait time.Duration, ok bool) {
+	for _, pref := range strings.Split(strings.Join(r.Header.Values("Prefer"), ","), ",") {
+		kv := strings.SplitN(strings.TrimSpace(pref), "=", 2)
+		if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "wait" {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.Trim(strings.TrimSpace(kv[1]), `"`))
+		if err != nil || secs <= 0 {
+			continue
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	return
+}
+
 func router(w http.ResponseWriter, r *http.Request) {
 	var format, urir, rawuri, rawdtm string
 	var dttm *time.Time
@@ -655,6 +982,12 @@ func router(w http.ResponseWriter, r *http.Request) {
 	orequri := r.URL.RequestURI()
 	requri := strings.TrimPrefix(orequri, *root)
 	endpoint := strings.SplitN(requri, "/", 2)[0]
+	if i := strings.Index(endpoint, "?"); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+	defer func() {
+		recordRequest(endpoint, format)
+	}()
 	switch endpoint {
 	case "timemap":
 		if regs["tmappth"].MatchString(requri) {
@@ -682,6 +1015,22 @@ func router(w http.ResponseWriter, r *http.Request) {
 		} else {
 			err = fmt.Errorf("/timegate/{URI-R}")
 		}
+	case "stream":
+		if regs["strmpth"].MatchString(requri) {
+			p := strings.SplitN(requri, "/", 3)
+			format = p[1]
+			rawuri = p[2]
+		} else {
+			err = fmt.Errorf("/stream/{FORMAT}/{URI-R} (FORMAT => json|cdxj)")
+		}
+	case "progress":
+		if regs["progpth"].MatchString(requri) {
+			p := strings.SplitN(requri, "/", 3)
+			format = p[1]
+			rawuri = p[2]
+		} else {
+			err = fmt.Errorf("/progress/{FORMAT}/{URI-R} (FORMAT => %s)", responseFormats)
+		}
 	case "memento", "api":
 		if regs["rdrcpth"].MatchString(requri) {
 			p := strings.SplitN(requri, "/", 3)
@@ -702,13 +1051,18 @@ func router(w http.ResponseWriter, r *http.Request) {
 		return
 	case "monitor":
 		if *monitor {
-			logInfo.Printf("Benchmark monitoring client connected")
-			broker.ServeHTTP(w, r)
+			monitorService(w, r)
 		} else {
 			logError.Printf("Benchmark monitoring not enabled, use --monitor flag to enable it")
 			http.Error(w, "Benchmark monitoring not enabled", http.StatusNotImplemented)
 		}
 		return
+	case "admin":
+		adminService(w, r, requri)
+		return
+	case "metrics":
+		metricsService(w, r)
+		return
 	default:
 		if *static != "" {
 			logInfo.Printf("Serving static file: %s", orequri)
@@ -729,6 +1083,9 @@ func router(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Malformed request: "+r.URL.RequestURI()+"\nExpected: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !admitClient(w, r) {
+		return
+	}
 	urir, err = parseURI(rawuri)
 	if err != nil {
 		logError.Printf("URI parsing error (%s): %v", rawuri, err)
@@ -743,6 +1100,14 @@ func router(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if endpoint == "stream" {
+		streamService(w, r, urir, format)
+		return
+	}
+	if endpoint == "progress" {
+		progressService(w, r, urir, format)
+		return
+	}
 	memgatorService(w, r, urir, format, dttm)
 }
 
@@ -771,28 +1136,35 @@ func serviceInfo() (msg string) {
 	msg += fmt.Sprintf("TimeMap:  %s/timemap/{FORMAT}/{URI-R}\n", *proxy)
 	msg += fmt.Sprintf("TimeGate: %s/timegate/{URI-R} [Accept-Datetime]\n", *proxy)
 	msg += fmt.Sprintf("Memento:  %s/memento[/{FORMAT}|proxy]/{DATETIME}/{URI-R}\n", *proxy)
+	msg += fmt.Sprintf("Stream:   %s/stream/{json|cdxj}/{URI-R}\n", *proxy)
+	msg += fmt.Sprintf("Progress: %s/progress/{FORMAT}/{URI-R} - (Over SSE)\n", *proxy)
 	msg += fmt.Sprintf("About:    %s/about\n", *proxy)
+	msg += fmt.Sprintf("Metrics:  %s/metrics - (Prometheus)\n", *proxy)
 	msg += "\n"
 	msg += fmt.Sprintf("  {FORMAT}          => %s\n", responseFormats)
 	msg += fmt.Sprintf("  {DATETIME}        => %s\n", validDatetimes)
 	msg += "  [Accept-Datetime] => Header in RFC1123 format\n"
 	msg += "\n\n"
 	msg += "## Upstream Archives\n"
-	for i, a := range archives {
+	for i, a := range currentArchives() {
 		name := a.Name
 		if name == "" {
 			name = a.ID
 		}
 		msg += fmt.Sprintf("\n%d. [%s](https://%s/)", i+1, name, a.ID)
-		if a.Dormant {
-			msg += " - (DORMANT)"
-		} else if a.Failures > 0 {
-			msg += fmt.Sprintf(" - (Consecutive failures: %d)", a.Failures)
+		if st := learnedStats(a.ID); st.Requests > 0 {
+			msg += fmt.Sprintf(" - (Probability: %.2f configured, %.2f learned; Latency: %s; Requests: %d)", a.Probability, st.HitRate, st.Latency.Round(time.Millisecond), st.Requests)
+		}
+		if st := a.breaker.Status(); st != "" {
+			msg += fmt.Sprintf(" - (%s)", st)
 		}
 	}
 	msg += "\n\n\n"
 	msg += "## Configs\n\n"
 	msg += fmt.Sprintf("Archives list location: %s\n", *arcsloc)
+	if *arcsreload > 0 {
+		msg += fmt.Sprintf("Archives list reload:   %s\n", *arcsreload)
+	}
 	ua := *agent
 	if *spoof {
 		ua = "A random browser UA - (SPOOFED)"
@@ -810,15 +1182,69 @@ func serviceInfo() (msg string) {
 	msg += fmt.Sprintf("Connection timeout:     %s\n", *contimeout)
 	msg += fmt.Sprintf("Header timeout:         %s\n", *hdrtimeout)
 	msg += fmt.Sprintf("Response timeout:       %s\n", *restimeout)
+	if *deadline > 0 {
+		msg += fmt.Sprintf("Aggregation deadline:   %s\n", *deadline)
+	}
+	if *retries > 0 {
+		msg += fmt.Sprintf("Retries:                %d (back-off from %s)\n", *retries, *retrywait)
+	}
+	if *ratelimit > 0 {
+		msg += fmt.Sprintf("Outbound rate limit:    %g/s (burst %d, wait up to %s)\n", *ratelimit, int(rateLimiter.burst), *ratewait)
+	}
+	if *fastgate > 0 {
+		msg += fmt.Sprintf("Fast TimeGate within:   %s of Accept-Datetime\n", *fastgate)
+	}
+	if *fastquorum > 0 {
+		msg += fmt.Sprintf("Fast TimeGate quorum:   %d archives\n", *fastquorum)
+	}
+	if *hedge {
+		msg += "Hedged TimeGate:        After p95 latency of each archive\n"
+	}
 	msg += "\n"
 	if *tolerance != -1 {
 		msg += fmt.Sprintf("Failure tolerance:      %d\n", *tolerance)
-		msg += fmt.Sprintf("Dormant period:         %s\n", *dormant)
+		msg += fmt.Sprintf("Dormant period:         %s (max %s)\n", *dormant, *maxdormant)
+		msg += fmt.Sprintf("Counted failures:       %s\n", *failon)
+		if failsOn("slow") {
+			msg += fmt.Sprintf("Slow response limit:    %s\n", *slowlimit)
+		}
 	}
 	if *topk != -1 {
 		msg += fmt.Sprintf("Select top archives:    %d\n", *topk)
 	}
-	if *tolerance != -1 || *topk != -1 {
+	if *adaptive {
+		msg += "Archive ordering:       Adaptive (learned hit rates)\n"
+	}
+	if *statsfile != "" {
+		msg += fmt.Sprintf("Learned stats file:     %s\n", *statsfile)
+	}
+	if *tolerance != -1 || *topk != -1 || *adaptive || *statsfile != "" {
+		msg += "\n"
+	}
+	if tmCache != nil {
+		msg += fmt.Sprintf("TimeMap cache:          %s\n", tmCache.Stats())
+	}
+	if tmDisk != nil {
+		msg += fmt.Sprintf("Persistent cache:       %s\n", tmDisk.Stats())
+	}
+	if tmCache != nil || tmDisk != nil {
+		msg += fmt.Sprintf("Cache TTL:              %s\n", *cachettl)
+		if *maxstale > 0 {
+			msg += fmt.Sprintf("Max staleness:          %s\n", *maxstale)
+		}
+		msg += "\n"
+	}
+	if apiKeys != nil {
+		msg += fmt.Sprintf("API keys:               %d from %s\n", len(apiKeys), *apikeys)
+	}
+	if *clientrate > 0 {
+		msg += fmt.Sprintf("Client rate limit:      %g/s per key or IP", *clientrate)
+		if *clientburst > 0 {
+			msg += fmt.Sprintf(" (burst %d)", *clientburst)
+		}
+		msg += "\n"
+	}
+	if apiKeys != nil || *clientrate > 0 {
 		msg += "\n"
 	}
 	logloc := "STDERR"
@@ -834,7 +1260,15 @@ func serviceInfo() (msg string) {
 		msg += "Verbose info output:    STDERR\n"
 	}
 	if *monitor {
-		msg += fmt.Sprintf("Monitor (Over SSE):     %s/monitor\n", *proxy)
+		msg += fmt.Sprintf("Monitor (Over SSE):     %s/monitor", *proxy)
+		if broker != nil {
+			clients, dropped, evicted := broker.Stats()
+			msg += fmt.Sprintf(" - (Clients: %d, Dropped events: %d, Evicted clients: %d)", clients, dropped, evicted)
+		}
+		msg += "\n"
+	}
+	if *admintoken != "" {
+		msg += fmt.Sprintf("Admin (Token auth):     %s/admin\n", *proxy)
 	}
 	return
 }
@@ -844,7 +1278,7 @@ func appInfo() (msg string) {
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, appInfo())
+	fmt.Fprint(os.Stderr, appInfo())
 	fmt.Fprintf(os.Stderr, "Usage:\n")
 	fmt.Fprintf(os.Stderr, "  %s [options] {URI-R}                            # TimeMap from CLI\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s [options] {URI-R} {%s} # Description of the closest Memento from CLI\n", os.Args[0], validDatetimes)
@@ -886,22 +1320,26 @@ func initLoggers() {
 	logBenchmark = log.New(benchmarkHandle, "BENCHMARK: ", log.Ldate|log.Lmicroseconds)
 }
 
-func initNetwork() {
-	transport = http.Transport{
+func newTransport(contimeout, hdrtimeout, restimeout time.Duration) *http.Transport {
+	return &http.Transport{
 		DialContext: (&net.Dialer{
-			Timeout:   *contimeout,
-			KeepAlive: *restimeout,
+			Timeout:   contimeout,
+			KeepAlive: restimeout,
 		}).DialContext,
-		ResponseHeaderTimeout: *hdrtimeout,
-		IdleConnTimeout:       *restimeout,
+		ResponseHeaderTimeout: hdrtimeout,
+		IdleConnTimeout:       restimeout,
 		MaxIdleConnsPerHost:   5,
 	}
-	client = http.Client{
-		Transport: &transport,
+}
+
+func initNetwork() {
+	transport = newTransport(*contimeout, *hdrtimeout, *restimeout)
+	client = &http.Client{
+		Transport: transport,
 		Timeout:   *restimeout,
 	}
 	reverseProxy = &httputil.ReverseProxy{
-		Transport:     &transport,
+		Transport:     proxyTransport{},
 		FlushInterval: time.Duration(100 * time.Millisecond),
 		Director: func(r *http.Request) {
 			r.URL.Path = regs["memdttm"].ReplaceAllString(r.URL.Path, "/${1}id_/")
@@ -909,6 +1347,22 @@ func initNetwork() {
 	}
 }
 
+func initCache() {
+	if flag.Arg(0) != "server" {
+		*maxstale = 0
+	}
+	if *cachesize > 0 {
+		tmCache = NewTimemapCache(*cachesize, *cachettl, *maxstale)
+	}
+	if *cachedir != "" {
+		var err error
+		tmDisk, err = NewDiskCache(*cachedir, *cachettl, *maxstale)
+		if err != nil {
+			logFatal.Fatalf("Error opening cache directory (%s): %v\n", *cachedir, err)
+		}
+	}
+}
+
 func main() {
 	start := time.Now()
 	flag.Usage = usage
@@ -923,33 +1377,44 @@ func main() {
 		flag.Usage()
 	}
 	initLoggers()
+	initFailureClasses()
 	initNetwork()
+	initRateLimit()
+	initCache()
 	logInfo.Printf("Initializing %s:%s...", Name, Version)
 	logInfo.Printf("Loading archives from %s", *arcsloc)
-	body, err := readArchives()
-	if err != nil {
-		logFatal.Fatalf("Error reading list of archives (%s): %s\n", *arcsloc, err)
-	}
-	err = json.Unmarshal(body, &archives)
-	archives.sanitize()
-	archives.filterIgnored()
-	sort.Sort(archives)
+	var err error
+	archives, err = loadArchives()
 	if err != nil {
-		logFatal.Fatalf("Error parsing JSON (%s): %s\n", *arcsloc, err)
+		logFatal.Fatalf("Error loading list of archives (%s): %s\n", *arcsloc, err)
 	}
+	loadLearned()
 	if target == "server" {
-		fmt.Printf(appInfo() + "\n" + serviceInfo())
+		watchArchives()
+		initClients()
+		go persistLearned()
+		fmt.Print(appInfo() + "\n" + serviceInfo())
 		if *agent == fmt.Sprintf("%s/%s <%s>", Name, Version, Repository) && !*spoof {
 			fmt.Print("\n\nATTENTION!\nConsider customizing the contact info or the whole user-agent.\nCheck CLI help (memgator --help) for options.\n\n")
 		}
 		if *monitor {
-			broker = sse.NewServer()
+			broker = sse.NewServer(monitorBuffer, monitorHistory)
 		}
-		addr := fmt.Sprintf(":%d", *port)
-		err = http.ListenAndServe(addr, http.HandlerFunc(router))
-		if err != nil {
+		srv := &http.Server{Addr: fmt.Sprintf(":%d", *port), Handler: http.HandlerFunc(router)}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			logInfo.Printf("Shutting down")
+			sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			srv.Shutdown(sctx)
+		}()
+		err = srv.ListenAndServe()
+		if err != http.ErrServerClosed {
 			logFatal.Fatalf("Error listening: %s\n", err)
 		}
+		saveLearned()
 	} else {
 		urir, err := parseURI(target)
 		if err != nil {
@@ -967,6 +1432,7 @@ func main() {
 			}
 		}
 		memgatorCli(urir, *format, dttm)
+		saveLearned()
 	}
 	elapsed := time.Since(start)
 	logInfo.Printf("Uptime: %s", elapsed)
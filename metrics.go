@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the request duration histograms
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram counts observations in cumulative buckets for the Prometheus text format
+type Histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	secs := d.Seconds()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+func (h *Histogram) write(w *strings.Builder, name string, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, le, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+var metrics = struct {
+	sync.Mutex
+	outcomes      map[[2]string]uint64
+	latencies     map[string]*Histogram
+	mementos      map[string]uint64
+	requests      map[[2]string]uint64
+	aggregation   *Histogram
+	serialization *Histogram
+}{
+	outcomes:      make(map[[2]string]uint64),
+	latencies:     make(map[string]*Histogram),
+	mementos:      make(map[string]uint64),
+	requests:      make(map[[2]string]uint64),
+	aggregation:   newHistogram(),
+	serialization: newHistogram(),
+}
+
+var metricsEndpoints = map[string]bool{
+	"timemap":  true,
+	"timegate": true,
+	"memento":  true,
+	"api":      true,
+	"stream":   true,
+	"progress": true,
+	"about":    true,
+	"monitor":  true,
+	"admin":    true,
+	"metrics":  true,
+}
+
+// recordArchive counts a response of the archive by its outcome - success, network_error, response_error, parse_error, or timeout
+func recordArchive(id string, outcome string, latency time.Duration, mementos int) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.outcomes[[2]string{id, outcome}]++
+	h, ok := metrics.latencies[id]
+	if !ok {
+		h = newHistogram()
+		metrics.latencies[id] = h
+	}
+	h.observe(latency)
+	metrics.mementos[id] += uint64(mementos)
+}
+
+func recordRequest(endpoint string, format string) {
+	if !metricsEndpoints[endpoint] {
+		endpoint = "other"
+	}
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.requests[[2]string{endpoint, strings.ToLower(format)}]++
+}
+
+func observeAggregation(d time.Duration) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.aggregation.observe(d)
+}
+
+func observeSerialization(d time.Duration) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.serialization.observe(d)
+}
+
+func sortedPairs(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+func sortedKeys(m map[string]*Histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeCacheLookups(w *strings.Builder, cache string, hits int64, stale int64, misses int64) {
+	fmt.Fprintf(w, "memgator_cache_lookups_total{cache=\"%s\",result=\"hit\"} %d\n", cache, hits)
+	fmt.Fprintf(w, "memgator_cache_lookups_total{cache=\"%s\",result=\"stale\"} %d\n", cache, stale)
+	fmt.Fprintf(w, "memgator_cache_lookups_total{cache=\"%s\",result=\"miss\"} %d\n", cache, misses)
+}
+
+// metricsText renders the metrics in the Prometheus text exposition format
+func metricsText() string {
+	var w strings.Builder
+	metrics.Lock()
+	w.WriteString("# HELP memgator_archive_requests_total Archive requests by outcome.\n")
+	w.WriteString("# TYPE memgator_archive_requests_total counter\n")
+	for _, k := range sortedPairs(metrics.outcomes) {
+		fmt.Fprintf(&w, "memgator_archive_requests_total{archive=\"%s\",outcome=\"%s\"} %d\n", k[0], k[1], metrics.outcomes[k])
+	}
+	w.WriteString("# HELP memgator_archive_request_duration_seconds Archive response times.\n")
+	w.WriteString("# TYPE memgator_archive_request_duration_seconds histogram\n")
+	for _, id := range sortedKeys(metrics.latencies) {
+		metrics.latencies[id].write(&w, "memgator_archive_request_duration_seconds", fmt.Sprintf("archive=\"%s\"", id))
+	}
+	w.WriteString("# HELP memgator_archive_mementos_total Mementos returned by archives.\n")
+	w.WriteString("# TYPE memgator_archive_mementos_total counter\n")
+	for _, id := range sortedKeys(metrics.latencies) {
+		fmt.Fprintf(&w, "memgator_archive_mementos_total{archive=\"%s\"} %d\n", id, metrics.mementos[id])
+	}
+	w.WriteString("# HELP memgator_requests_total Requests served by endpoint and format.\n")
+	w.WriteString("# TYPE memgator_requests_total counter\n")
+	for _, k := range sortedPairs(metrics.requests) {
+		fmt.Fprintf(&w, "memgator_requests_total{endpoint=\"%s\",format=\"%s\"} %d\n", k[0], k[1], metrics.requests[k])
+	}
+	w.WriteString("# HELP memgator_aggregation_duration_seconds TimeMap aggregation times.\n")
+	w.WriteString("# TYPE memgator_aggregation_duration_seconds histogram\n")
+	metrics.aggregation.write(&w, "memgator_aggregation_duration_seconds", "")
+	w.WriteString("# HELP memgator_serialization_duration_seconds TimeMap serialization times.\n")
+	w.WriteString("# TYPE memgator_serialization_duration_seconds histogram\n")
+	metrics.serialization.write(&w, "memgator_serialization_duration_seconds", "")
+	metrics.Unlock()
+	w.WriteString("# HELP memgator_archive_dormant Whether the archive is dormant after consecutive failures.\n")
+	w.WriteString("# TYPE memgator_archive_dormant gauge\n")
+	for _, arch := range currentArchives() {
+		dormant := 0
+		if arch.breaker.State() == Open {
+			dormant = 1
+		}
+		fmt.Fprintf(&w, "memgator_archive_dormant{archive=\"%s\"} %d\n", arch.ID, dormant)
+	}
+	if tmCache != nil {
+		w.WriteString("# HELP memgator_cache_entries TimeMaps held in the in-memory cache.\n")
+		w.WriteString("# TYPE memgator_cache_entries gauge\n")
+		fmt.Fprintf(&w, "memgator_cache_entries %d\n", tmCache.Len())
+	}
+	if tmCache != nil || tmDisk != nil {
+		w.WriteString("# HELP memgator_cache_lookups_total Cache lookups by result.\n")
+		w.WriteString("# TYPE memgator_cache_lookups_total counter\n")
+	}
+	if tmCache != nil {
+		hits, stale, misses := tmCache.Counters()
+		writeCacheLookups(&w, "memory", hits, stale, misses)
+	}
+	if tmDisk != nil {
+		hits, stale, misses := tmDisk.Counters()
+		writeCacheLookups(&w, "disk", hits, stale, misses)
+	}
+	return w.String()
+}
+
+func metricsService(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, metricsText())
+}
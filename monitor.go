@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MonitorEvent is a benchmark event as sent to the monitoring clients, with a summary for the rolled-up events of finished sessions
+type MonitorEvent struct {
+	Session string          `json:"session"`
+	Origin  string          `json:"origin"`
+	Role    string          `json:"role"`
+	Info    string          `json:"info"`
+	Start   int64           `json:"start"`
+	End     int64           `json:"end"`
+	Summary json.RawMessage `json:"summary,omitempty"`
+}
+
+// sessionRollup accumulates the events of a session until it finishes
+type sessionRollup struct {
+	events   int
+	archives map[string]string
+	last     time.Time
+}
+
+var rollups = struct {
+	sync.Mutex
+	sessions map[int64]*sessionRollup
+}{sessions: make(map[int64]*sessionRollup)}
+
+// rollupTTL is how long the events of a session that never finished are kept
+const rollupTTL = time.Hour
+
+// monitorBuffer is the number of events a monitoring client can fall behind before it is evicted
+const monitorBuffer = 256
+
+// monitorHistory is the number of recent events replayed to monitoring clients reconnecting with a Last-Event-ID
+const monitorHistory = 1024
+
+func splitParam(r *http.Request, key string) map[string]bool {
+	vals := make(map[string]bool)
+	for _, v := range r.URL.Query()[key] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				vals[part] = true
+			}
+		}
+	}
+	return vals
+}
+
+// monitorFilter builds a filter of the events by the session, origin, and role query parameters (comma separated or repeated), sending only the rolled-up events of finished sessions if the summary parameter is "sessions"
+func monitorFilter(r *http.Request) func([]byte) bool {
+	sessions := splitParam(r, "session")
+	origins := splitParam(r, "origin")
+	roles := splitParam(r, "role")
+	summary := r.URL.Query().Get("summary") == "sessions"
+	return func(event []byte) bool {
+		var ev MonitorEvent
+		if err := json.Unmarshal(event, &ev); err != nil {
+			return false
+		}
+		if summary != (ev.Summary != nil) {
+			return false
+		}
+		if len(sessions) > 0 && !sessions[ev.Session] {
+			return false
+		}
+		if len(origins) > 0 && !origins[ev.Origin] {
+			return false
+		}
+		if len(roles) > 0 && !roles[ev.Role] {
+			return false
+		}
+		return true
+	}
+}
+
+// rollupEvent adds an event to its session, returning the rolled-up event of the session once its SESSION event arrives
+func rollupEvent(begin int64, origin string, role string, info string, end time.Time) (event string, done bool) {
+	rollups.Lock()
+	defer rollups.Unlock()
+	ru, ok := rollups.sessions[begin]
+	if !ok {
+		ru = &sessionRollup{archives: make(map[string]string)}
+		rollups.sessions[begin] = ru
+	}
+	ru.events++
+	ru.last = end
+	if origin != "SESSION" && origin != "AGGREGATOR" {
+		ru.archives[origin] = info
+	}
+	if origin != "SESSION" {
+		return
+	}
+	delete(rollups.sessions, begin)
+	for id, r := range rollups.sessions {
+		if end.Sub(r.last) > rollupTTL {
+			delete(rollups.sessions, id)
+		}
+	}
+	ids := make([]string, 0, len(ru.archives))
+	for id := range ru.archives {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	arcs := make([]string, len(ids))
+	for i, id := range ids {
+		arcs[i] = fmt.Sprintf(`"%s": "%s"`, id, ru.archives[id])
+	}
+	summary := fmt.Sprintf(`{"events": %d, "duration": "%s", "archives": {%s}}`, ru.events, end.Sub(time.Unix(0, begin)), strings.Join(arcs, ", "))
+	event = fmt.Sprintf(`{"session": "%d", "origin": "%s", "role": "%s", "info": "%s", "start": %d, "end": %d, "summary": %s}`, begin, origin, role, info, begin, end.UnixNano(), summary)
+	return event, true
+}
+
+func monitorService(w http.ResponseWriter, r *http.Request) {
+	logInfo.Printf("Benchmark monitoring client connected")
+	broker.ServeFiltered(w, r, monitorFilter(r))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Profile is a MementoMap-style summary of the URI-R SURT keys (or key prefixes ending with *) an archive holds
+type Profile struct {
+	Complete bool
+	keys     map[string]int
+	prefixes map[string]int
+}
+
+// ProfileMeta holds the fields of the !meta lines of a profile that MemGator understands
+type ProfileMeta struct {
+	Complete bool `json:"complete"`
+}
+
+func loadProfile(fp string) (prof *Profile, err error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	prof = &Profile{
+		keys:     make(map[string]int),
+		prefixes: make(map[string]int),
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for ln := 1; scanner.Scan(); ln++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "!meta ") {
+			var meta ProfileMeta
+			if json.Unmarshal([]byte(line[6:]), &meta) == nil && meta.Complete {
+				prof.Complete = true
+			}
+			continue
+		}
+		if line[0] == '!' {
+			continue
+		}
+		fields := strings.Fields(line)
+		count := 1
+		if len(fields) > 1 {
+			count, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d => %v", fp, ln, err)
+			}
+		}
+		key := fields[0]
+		if strings.HasSuffix(key, "*") {
+			prof.prefixes[strings.TrimSuffix(key, "*")] = count
+		} else {
+			prof.keys[key] = count
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// lookup returns the memento count of the exact or the longest matching prefix key of the SURT
+func (prof *Profile) lookup(surt string) (count int, found bool) {
+	if count, found = prof.keys[surt]; found {
+		return
+	}
+	for i := len(surt); i >= 0; i-- {
+		if count, found = prof.prefixes[surt[:i]]; found {
+			return
+		}
+	}
+	return
+}
+
+// score estimates how likely the archive holds the URI-R, a negative score being a certain miss
+func (arch *Archive) score(uk *URIKey) float64 {
+	if arch.profile == nil {
+		if *adaptive {
+			return arch.learnedScore()
+		}
+		return arch.Probability
+	}
+	count, found := arch.profile.lookup(uk.SURT)
+	if !found {
+		if arch.profile.Complete {
+			return -1
+		}
+		return 0
+	}
+	if count <= 0 {
+		return -1
+	}
+	return 1 - 1/float64(1+count)
+}
+
+func (a Archives) initProfiles() (err error) {
+	for _, arch := range a {
+		if arch.Profile == "" {
+			continue
+		}
+		arch.profile, err = loadProfile(arch.Profile)
+		if err != nil {
+			return fmt.Errorf("%s => %v", arch.ID, err)
+		}
+	}
+	return
+}
+
+type scoredArchives struct {
+	arcs   Archives
+	scores []float64
+}
+
+func (sa scoredArchives) Len() int {
+	return len(sa.arcs)
+}
+
+func (sa scoredArchives) Less(i, j int) bool {
+	return sa.scores[i] > sa.scores[j]
+}
+
+func (sa scoredArchives) Swap(i, j int) {
+	sa.arcs[i], sa.arcs[j] = sa.arcs[j], sa.arcs[i]
+	sa.scores[i], sa.scores[j] = sa.scores[j], sa.scores[i]
+}
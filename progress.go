@@ -0,0 +1,113 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ProgressEvent is a step of an archive in an aggregation - started, mementos, failed, skipped, or timedout
+type ProgressEvent struct {
+	Event    string `json:"event"`
+	Archive  string `json:"archive"`
+	Name     string `json:"name"`
+	Mementos int    `json:"mementos"`
+	Info     string `json:"info,omitempty"`
+	Elapsed  string `json:"elapsed"`
+}
+
+// report sends a progress event of the archive to the session, if a client is following it
+func (sess *Session) report(event string, arch *Archive, mementos int, info string) {
+	if sess.progress == nil {
+		return
+	}
+	sess.progress <- ProgressEvent{
+		Event:    event,
+		Archive:  arch.ID,
+		Name:     arch.Name,
+		Mementos: mementos,
+		Info:     info,
+		Elapsed:  time.Since(sess.Start).String(),
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, data string) {
+	fmt.Fprintf(w, "event: %s\n", event)
+	for _, line := range strings.Split(strings.TrimRight(data, "\n"), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// progressService streams the progress of each archive of an aggregation over SSE, ending with the merged TimeMap
+func progressService(w http.ResponseWriter, r *http.Request, urir string, format string) {
+	start := time.Now()
+	sess := newSession(start)
+	if wait, ok := preferWait(r); ok {
+		sess.Deadline = start.Add(wait)
+		w.Header().Set("Preference-Applied", fmt.Sprintf("wait=%d", int(wait.Seconds())))
+	}
+	defer benchmarker("SESSION", "progress", "Complete session", start, sess)
+	benchmarker("AGGREGATOR", "createsess", "Session created", start, sess)
+	logInfo.Printf("Aggregating Mementos with progress for %s", urir)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	ctx := r.Context()
+	sess.progress = make(chan ProgressEvent, 16)
+	var basetm *list.List
+	joined := false
+	go func() {
+		defer close(sess.progress)
+		if ce, ok := cacheGet(urir); ok && !ce.Expired(*cachettl) {
+			sess.Cached = ce.Fetched
+			basetm = copyTimemap(ce.Timemap)
+			return
+		}
+		if basetm, joined = joinTimemap(ctx, urir, sess); joined {
+			return
+		}
+		basetm = aggregateTimemap(ctx, urir, nil, sess)
+		if basetm.Len() > 0 && ctx.Err() == nil && !sess.partial() {
+			storeTimemap(urir, basetm)
+		}
+	}()
+	for ev := range sess.progress {
+		if ctx.Err() != nil {
+			continue
+		}
+		data, err := json.Marshal(ev)
+		if err == nil {
+			writeEvent(w, "archive", string(data))
+		}
+	}
+	if ctx.Err() != nil {
+		logInfo.Printf("Client disconnected while aggregating %s", urir)
+		return
+	}
+	if !sess.Cached.IsZero() {
+		writeEvent(w, "cached", fmt.Sprintf(`{"fetched": "%s"}`, sess.Cached.UTC().Format(time.RFC3339)))
+	}
+	if joined {
+		writeEvent(w, "joined", fmt.Sprintf(`{"mementos": %d}`, basetm.Len()))
+	}
+	navonly := false
+	if basetm.Len() > 0 {
+		navonly, _ = setNavRels(basetm, nil, sess)
+	}
+	dataCh := make(chan string, 1)
+	go serializeLinks(ctx, urir, basetm, format, dataCh, navonly, sess)
+	result := ""
+	for dt := range dataCh {
+		result += dt
+	}
+	writeEvent(w, "result", result)
+	logInfo.Printf("Total Mementos: %d in %s", basetm.Len(), time.Since(start))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket allows a sustained rate of requests per second with bursts of up to its capacity
+type TokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+var rateLimiter *TokenBucket
+
+func newTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (tb *TokenBucket) refill(now time.Time) {
+	tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+}
+
+// reserve takes a token and returns how long to wait before using it, unless that is longer than the maximum wait
+func (tb *TokenBucket) reserve(maxwait time.Duration) (wait time.Duration, ok bool) {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.refill(time.Now())
+	if tb.tokens < 1 {
+		wait = time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+		if wait > maxwait {
+			return 0, false
+		}
+	}
+	tb.tokens--
+	return wait, true
+}
+
+// unreserve gives back a token that was reserved but not used
+func (tb *TokenBucket) unreserve() {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.refill(time.Now())
+	tb.tokens = math.Min(tb.burst, tb.tokens+1)
+}
+
+// throttle waits for a token of the archive and the global rate limits, giving up if that takes longer than the rate wait or the request context allows
+func (arch *Archive) throttle(ctx context.Context) bool {
+	maxwait := *ratewait
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) < maxwait {
+		maxwait = time.Until(dl)
+	}
+	var wait time.Duration
+	var taken []*TokenBucket
+	giveBack := func() {
+		for _, tb := range taken {
+			tb.unreserve()
+		}
+	}
+	for _, tb := range []*TokenBucket{arch.limiter, rateLimiter} {
+		if tb == nil {
+			continue
+		}
+		w, ok := tb.reserve(maxwait)
+		if !ok {
+			giveBack()
+			return false
+		}
+		taken = append(taken, tb)
+		if w > wait {
+			wait = w
+		}
+	}
+	if wait == 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		giveBack()
+		return false
+	}
+}
+
+func (a Archives) initLimiters() {
+	for _, arch := range a {
+		if arch.RateLimit > 0 {
+			arch.limiter = newTokenBucket(arch.RateLimit, arch.RateBurst)
+		}
+	}
+}
+
+func initRateLimit() {
+	if *ratelimit > 0 {
+		rateLimiter = newTokenBucket(*ratelimit, *rateburst)
+	}
+}
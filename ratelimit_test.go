@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewTokenBucketBurst(t *testing.T) {
+	tests := []struct {
+		rate  float64
+		burst int
+		want  float64
+	}{
+		{2.5, 0, 3},
+		{0.5, 0, 1},
+		{5, 2, 2},
+		{5, -1, 5},
+	}
+	for _, tt := range tests {
+		if got := newTokenBucket(tt.rate, tt.burst).burst; got != tt.want {
+			t.Errorf("newTokenBucket(%g, %d).burst = %g, want %g", tt.rate, tt.burst, got, tt.want)
+		}
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	tb := newTokenBucket(1, 2)
+	tests := []struct {
+		name      string
+		unreserve bool
+		maxwait   time.Duration
+		ok        bool
+		wait      time.Duration
+	}{
+		{"first burst token", false, 0, true, 0},
+		{"second burst token", false, 0, true, 0},
+		{"bucket empty", false, 0, false, 0},
+		{"next token within the wait", false, 2 * time.Second, true, time.Second},
+		{"token after next beyond the wait", false, 1500 * time.Millisecond, false, 0},
+		{"token given back", true, 1500 * time.Millisecond, true, time.Second},
+	}
+	for _, tt := range tests {
+		if tt.unreserve {
+			tb.unreserve()
+		}
+		wait, ok := tb.reserve(tt.maxwait)
+		if ok != tt.ok {
+			t.Fatalf("%s: reserve(%s) ok = %v, want %v", tt.name, tt.maxwait, ok, tt.ok)
+		}
+		if wait > tt.wait || wait < tt.wait-50*time.Millisecond {
+			t.Fatalf("%s: reserve(%s) wait = %s, want %s", tt.name, tt.maxwait, wait, tt.wait)
+		}
+	}
+}
+
+func TestTokenBucketUnreserveCapped(t *testing.T) {
+	tb := newTokenBucket(0.1, 2)
+	tb.unreserve()
+	for i, want := range []bool{true, true, false} {
+		if _, ok := tb.reserve(0); ok != want {
+			t.Errorf("reserve #%d after unreserve on a full bucket: ok = %v, want %v", i+1, ok, want)
+		}
+	}
+}
+
+func TestThrottleGivesBackTokens(t *testing.T) {
+	defer func(rl *TokenBucket, rw time.Duration) { rateLimiter, *ratewait = rl, rw }(rateLimiter, *ratewait)
+	*ratewait = 10 * time.Millisecond
+	rateLimiter = newTokenBucket(0.1, 3)
+	arch := &Archive{ID: "test", limiter: newTokenBucket(0.1, 1)}
+	tests := []struct {
+		name   string
+		ok     bool
+		global float64
+	}{
+		{"archive and global tokens taken", true, 2},
+		{"archive limit reached, global token given back", false, 2},
+	}
+	for _, tt := range tests {
+		if ok := arch.throttle(context.Background()); ok != tt.ok {
+			t.Fatalf("%s: throttle() = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if got := rateLimiter.tokens; got < tt.global || got > tt.global+0.01 {
+			t.Fatalf("%s: global tokens = %g, want %g", tt.name, got, tt.global)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func archiveConfig(arch *Archive) string {
+	cfg, err := json.Marshal(arch)
+	if err != nil {
+		return ""
+	}
+	return string(cfg)
+}
+
+// inherit carries the breaker and limits of an unchanged archive over to its reloaded instance
+func (arch *Archive) inherit(prev *Archive) {
+	arch.breaker = prev.breaker
+	arch.limiter = prev.limiter
+	arch.slots = prev.slots
+}
+
+// reloadArchives reads the list of archives again and swaps it in, the archives that did not change keeping their state
+func reloadArchives() (arcs Archives, err error) {
+	start := time.Now()
+	arcs, err = loadArchives()
+	if err != nil {
+		logError.Printf("Error reloading list of archives (%s): %v", *arcsloc, err)
+		return
+	}
+	archivesMu.Lock()
+	defer archivesMu.Unlock()
+	old := make(map[string]*Archive, len(archives))
+	for _, arch := range archives {
+		old[archiveConfig(arch)] = arch
+	}
+	kept := 0
+	for _, arch := range arcs {
+		if prev, ok := old[archiveConfig(arch)]; ok {
+			arch.inherit(prev)
+			kept++
+		}
+	}
+	archives = arcs
+	logInfo.Printf("Reloaded %d archives (%d unchanged) from %s in %s", len(arcs), kept, *arcsloc, time.Since(start))
+	return
+}
+
+func watchArchives() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			logInfo.Printf("SIGHUP received, reloading list of archives")
+			reloadArchives()
+		}
+	}()
+	if *arcsreload > 0 {
+		go func() {
+			for range time.Tick(*arcsreload) {
+				reloadArchives()
+			}
+		}()
+	}
+}
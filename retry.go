@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// cancelBody releases the context of a request once its response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (cb cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
+
+func retryableError(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func retryableStatus(res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	case http.StatusServiceUnavailable:
+		return res.Header.Get("Retry-After") == ""
+	}
+	return false
+}
+
+// jitter returns a random wait of up to the retry wait doubled for each previous attempt
+func jitter(attempt int) time.Duration {
+	ceil := *retrywait << uint(attempt)
+	if ceil <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceil)))
+}
+
+// send makes the request to the archive, retrying transient failures with jittered back-off within the response timeout of the archive
+func (arch *Archive) send(req *http.Request, timegate bool, sess *Session) (res *http.Response, err error) {
+	retries := *retries
+	if arch.Retries != 0 {
+		retries = arch.Retries
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), arch.client.Timeout)
+	req = req.WithContext(ctx)
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		if timegate && *hedge {
+			res, err = arch.hedge(req, sess)
+		} else if timegate {
+			res, err = arch.transport.RoundTrip(req)
+		} else {
+			res, err = arch.client.Do(req)
+		}
+		retry := false
+		reason := ""
+		if err != nil {
+			retry = retryableError(err)
+			reason = err.Error()
+		} else if retryableStatus(res) {
+			retry = true
+			reason = res.Status
+		}
+		if !retry || attempt >= retries {
+			break
+		}
+		wait := jitter(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			break
+		}
+		benchmarker(arch.ID, "timemapfetch", fmt.Sprintf("Attempt %d failed in %s, retrying in %s", attempt+1, arch.Name, wait.Round(time.Millisecond)), start, sess)
+		logInfo.Printf("%s => Retrying after attempt %d failed: %s", arch.ID, attempt+1, reason)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+		if !arch.throttle(ctx) && ctx.Err() == nil {
+			logInfo.Printf("%s => Not retrying after attempt %d failed: rate limit reached", arch.ID, attempt+1)
+			break
+		}
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+	}
+	if err != nil {
+		cancel()
+		return
+	}
+	res.Body = cancelBody{res.Body, cancel}
+	return
+}
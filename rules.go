@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// URIRules lists patterns of URI-Rs by host globs, TLDs, SURT prefixes, and regular expressions
+type URIRules struct {
+	Hosts   []string `json:"hosts,omitempty"`
+	TLDs    []string `json:"tlds,omitempty"`
+	SURTs   []string `json:"surts,omitempty"`
+	Regexes []string `json:"regexes,omitempty"`
+	regexes []*regexp.Regexp
+}
+
+// URIKey holds the forms of a URI-R that the rules are matched against
+type URIKey struct {
+	URIR string
+	Host string
+	SURT string
+}
+
+func newURIKey(urir string) (uk *URIKey) {
+	uk = &URIKey{URIR: urir}
+	u, err := url.Parse(urir)
+	if err != nil {
+		return
+	}
+	uk.Host = strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	parts := strings.Split(strings.TrimPrefix(uk.Host, "www."), ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	uk.SURT = strings.Join(parts, ",")
+	if p := u.Port(); p != "" && p != "80" && p != "443" {
+		uk.SURT += ":" + p
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	uk.SURT += ")" + strings.ToLower(p)
+	if u.RawQuery != "" {
+		uk.SURT += "?" + strings.ToLower(u.RawQuery)
+	}
+	return
+}
+
+func (ur *URIRules) compile() (err error) {
+	ur.regexes = make([]*regexp.Regexp, len(ur.Regexes))
+	for i, rx := range ur.Regexes {
+		ur.regexes[i], err = regexp.Compile(rx)
+		if err != nil {
+			return
+		}
+	}
+	for i, tld := range ur.TLDs {
+		ur.TLDs[i] = strings.ToLower(strings.Trim(tld, "."))
+	}
+	for i, hg := range ur.Hosts {
+		ur.Hosts[i] = strings.ToLower(hg)
+	}
+	return
+}
+
+func (ur *URIRules) empty() bool {
+	return len(ur.Hosts)+len(ur.TLDs)+len(ur.SURTs)+len(ur.Regexes) == 0
+}
+
+func (ur *URIRules) match(uk *URIKey) bool {
+	for _, hg := range ur.Hosts {
+		if ok, _ := path.Match(hg, uk.Host); ok {
+			return true
+		}
+	}
+	for _, tld := range ur.TLDs {
+		if uk.Host == tld || strings.HasSuffix(uk.Host, "."+tld) {
+			return true
+		}
+	}
+	for _, sp := range ur.SURTs {
+		if strings.HasPrefix(uk.SURT, sp) {
+			return true
+		}
+	}
+	for _, rx := range ur.regexes {
+		if rx.MatchString(uk.URIR) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Archives) initRules() (err error) {
+	for _, arch := range a {
+		for _, ur := range []*URIRules{arch.Include, arch.Exclude} {
+			if ur == nil {
+				continue
+			}
+			err = ur.compile()
+			if err != nil {
+				return fmt.Errorf("%s => %v", arch.ID, err)
+			}
+		}
+	}
+	return
+}
+
+// routable tells whether the URI-R is included and not excluded by the rules of the archive
+func (arch *Archive) routable(uk *URIKey) bool {
+	if arch.Include != nil && !arch.Include.empty() && !arch.Include.match(uk) {
+		return false
+	}
+	if arch.Exclude != nil && arch.Exclude.match(uk) {
+		return false
+	}
+	return true
+}
+
+// route returns the archives that may hold the URI-R, ordered by their profile scores
+func (a Archives) route(urir string) Archives {
+	uk := newURIKey(urir)
+	sa := scoredArchives{}
+	for _, arch := range a {
+		if !arch.routable(uk) {
+			continue
+		}
+		score := arch.score(uk)
+		if score < 0 {
+			continue
+		}
+		sa.arcs = append(sa.arcs, arch)
+		sa.scores = append(sa.scores, score)
+	}
+	sort.Stable(sa)
+	return sa.arcs
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// learnRate is the weight of each new response in the moving averages of the learned stats
+const learnRate = 0.1
+
+// latencySamples is the number of recent TimeGate latencies kept per archive for percentiles
+const latencySamples = 100
+
+// ArchiveStats holds the hit rate (of non-empty TimeMaps) and the latency of an archive learned from its live responses
+type ArchiveStats struct {
+	Requests int64    `json:"requests"`
+	Hits     int64    `json:"hits"`
+	HitRate  float64  `json:"hitrate"`
+	Latency  Duration `json:"latency"`
+}
+
+var learned = struct {
+	sync.Mutex
+	stats map[string]*ArchiveStats
+}{stats: make(map[string]*ArchiveStats)}
+
+func learnArchive(arch *Archive, hit bool, latency time.Duration) {
+	learned.Lock()
+	defer learned.Unlock()
+	st, ok := learned.stats[arch.ID]
+	if !ok {
+		st = &ArchiveStats{
+			HitRate: arch.prior(),
+			Latency: Duration{latency},
+		}
+		learned.stats[arch.ID] = st
+	}
+	st.Requests++
+	h := 0.0
+	if hit {
+		st.Hits++
+		h = 1
+	}
+	st.HitRate += learnRate * (h - st.HitRate)
+	st.Latency.Duration += time.Duration(learnRate * float64(latency-st.Latency.Duration))
+}
+
+// timegateLatencies holds the recent TimeGate response times of each archive, measured from sending the request, apart from the TimeMap downloads
+var timegateLatencies = struct {
+	sync.Mutex
+	samples map[string][]time.Duration
+	count   map[string]int
+}{samples: make(map[string][]time.Duration), count: make(map[string]int)}
+
+func learnTimegate(id string, latency time.Duration) {
+	timegateLatencies.Lock()
+	defer timegateLatencies.Unlock()
+	recent := timegateLatencies.samples[id]
+	if len(recent) < latencySamples {
+		timegateLatencies.samples[id] = append(recent, latency)
+	} else {
+		recent[timegateLatencies.count[id]%latencySamples] = latency
+	}
+	timegateLatencies.count[id]++
+}
+
+// timegatePercentile returns the p-th percentile of the recent TimeGate latencies of the archive, if there are enough samples
+func timegatePercentile(id string, p float64) (d time.Duration, ok bool) {
+	timegateLatencies.Lock()
+	samples := timegateLatencies.samples[id]
+	if len(samples) < latencySamples/5 {
+		timegateLatencies.Unlock()
+		return
+	}
+	recent := make([]time.Duration, len(samples))
+	copy(recent, samples)
+	timegateLatencies.Unlock()
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i] < recent[j]
+	})
+	return recent[int(p*float64(len(recent)-1))], true
+}
+
+func learnedStats(id string) (st ArchiveStats) {
+	learned.Lock()
+	defer learned.Unlock()
+	if s, ok := learned.stats[id]; ok {
+		st = *s
+	}
+	return
+}
+
+func (arch *Archive) prior() float64 {
+	if arch.Probability > 0 {
+		return arch.Probability
+	}
+	return 0.5
+}
+
+// learnedScore is the learned hit rate, discounted by up to a half for archives that use up their response timeout
+func (arch *Archive) learnedScore() float64 {
+	st := learnedStats(arch.ID)
+	if st.Requests == 0 {
+		return arch.prior()
+	}
+	slowness := float64(st.Latency.Duration) / float64(arch.client.Timeout)
+	if slowness > 1 {
+		slowness = 1
+	}
+	return st.HitRate * (1 - slowness/2)
+}
+
+func loadLearned() {
+	if *statsfile == "" {
+		return
+	}
+	body, err := os.ReadFile(*statsfile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError.Printf("Error reading learned stats (%s): %v", *statsfile, err)
+		}
+		return
+	}
+	stats := make(map[string]*ArchiveStats)
+	err = json.Unmarshal(body, &stats)
+	if err != nil {
+		logError.Printf("Error parsing learned stats (%s): %v", *statsfile, err)
+		return
+	}
+	learned.Lock()
+	learned.stats = stats
+	learned.Unlock()
+	logInfo.Printf("Loaded learned stats of %d archives from %s", len(stats), *statsfile)
+}
+
+// writeAtomic writes the file through a temporary file renamed over it, so that the CLI and the server never see each other's partial writes
+func writeAtomic(fp string, body []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(fp), ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	return os.Rename(tmp.Name(), fp)
+}
+
+func saveLearned() {
+	if *statsfile == "" {
+		return
+	}
+	learned.Lock()
+	body, err := json.MarshalIndent(learned.stats, "", "  ")
+	learned.Unlock()
+	if err == nil {
+		err = writeAtomic(*statsfile, body)
+	}
+	if err != nil {
+		logError.Printf("Error saving learned stats (%s): %v", *statsfile, err)
+	}
+}
+
+func persistLearned() {
+	if *statsfile == "" {
+		return
+	}
+	for range time.Tick(time.Minute) {
+		saveLearned()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var streamMimeMap = map[string]string{
+	"json": "application/x-ndjson",
+	"cdxj": "application/cdxj+ors",
+}
+
+// writeStreamed writes the mementos of a TimeMap, unordered across archives, as NDJSON or CDXJ records tagged with their archive
+func writeStreamed(w http.ResponseWriter, tml *list.List, format string, counts map[string]int) {
+	for e := tml.Front(); e != nil; e = e.Next() {
+		lnk := e.Value.(Link)
+		counts[lnk.Archive]++
+		switch format {
+		case "json":
+			fmt.Fprintf(w, `{"archive": "%s", "datetime": "%s", "uri": "%s"}`+"\n", lnk.Archive, lnk.Timeobj.Format(time.RFC3339), lnk.Href)
+		case "cdxj":
+			fmt.Fprintf(w, `%s {"uri": "%s", "rel": "memento", "datetime": "%s", "archive": "%s"}`+"\n", lnk.Timestr, lnk.Href, lnk.Datetime, lnk.Archive)
+		}
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func streamSummary(urir string, total int, counts map[string]int, sess *Session) string {
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	arcs := make([]string, len(ids))
+	for i, id := range ids {
+		arcs[i] = fmt.Sprintf(`"%s": %d`, id, counts[id])
+	}
+	timedout := ""
+	if len(sess.TimedOut) > 0 {
+		timedout = `"` + strings.Join(sess.TimedOut, `", "`) + `"`
+	}
+	skipped := ""
+	if len(sess.Skipped) > 0 {
+		skipped = `"` + strings.Join(sess.Skipped, `", "`) + `"`
+	}
+	cached := "null"
+	if !sess.Cached.IsZero() {
+		cached = `"` + sess.Cached.UTC().Format(time.RFC3339) + `"`
+	}
+	return fmt.Sprintf(`{"original_uri": "%s", "mementos": %d, "archives": {%s}, "timedout_archives": [%s], "skipped_archives": [%s], "cached": %s, "duration": "%s"}`, urir, total, strings.Join(arcs, ", "), timedout, skipped, cached, time.Since(sess.Start))
+}
+
+// streamService writes the mementos of each archive as soon as its TimeMap arrives, followed by a summary record
+func streamService(w http.ResponseWriter, r *http.Request, urir string, format string) {
+	start := time.Now()
+	sess := newSession(start)
+	if wait, ok := preferWait(r); ok {
+		sess.Deadline = start.Add(wait)
+		w.Header().Set("Preference-Applied", fmt.Sprintf("wait=%d", int(wait.Seconds())))
+	}
+	defer benchmarker("SESSION", "stream", "Complete session", start, sess)
+	benchmarker("AGGREGATOR", "createsess", "Session created", start, sess)
+	logInfo.Printf("Streaming Mementos for %s", urir)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", streamMimeMap[format])
+	if format == "cdxj" {
+		fmt.Fprintf(w, `!context ["https://oduwsdl.github.io/contexts/memento"]`+"\n")
+		fmt.Fprintf(w, `!keys ["memento_datetime_YYYYMMDDhhmmss"]`+"\n")
+		fmt.Fprintf(w, `!meta {"original_uri": "%s"}`+"\n", urir)
+	}
+	ctx := r.Context()
+	total := 0
+	counts := make(map[string]int)
+	if ce, ok := cacheGet(urir); ok && !ce.Expired(*cachettl) {
+		sess.Cached = ce.Fetched
+		total = ce.Timemap.Len()
+		writeStreamed(w, ce.Timemap, format, counts)
+	} else if basetm, ok := joinTimemap(ctx, urir, sess); ok {
+		total = basetm.Len()
+		writeStreamed(w, basetm, format, counts)
+	} else {
+		basetm := list.New()
+		for tml := range fetchTimemaps(ctx, urir, nil, sess) {
+			if tml.Len() == 0 || ctx.Err() != nil {
+				continue
+			}
+			begin := time.Now()
+			total += tml.Len()
+			writeStreamed(w, tml, format, counts)
+			basetm = mergeTimemaps(basetm, tml)
+			benchmarker("AGGREGATOR", "stream", fmt.Sprintf("%d Mementos streamed", tml.Len()), begin, sess)
+		}
+		sort.Strings(sess.TimedOut)
+		sort.Strings(sess.Skipped)
+		if basetm.Len() > 0 && ctx.Err() == nil && !sess.partial() {
+			storeTimemap(urir, basetm)
+		}
+	}
+	if ctx.Err() != nil {
+		logInfo.Printf("Client disconnected while streaming %s", urir)
+		return
+	}
+	summary := streamSummary(urir, total, counts, sess)
+	switch format {
+	case "json":
+		fmt.Fprintf(w, `{"summary": %s}`+"\n", summary)
+	case "cdxj":
+		fmt.Fprintf(w, `!meta {"summary": %s}`+"\n", summary)
+	}
+	logInfo.Printf("Total Mementos: %d streamed in %s", total, time.Since(start))
+}